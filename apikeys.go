@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix starts every API key, so the session middleware can tell keys
+// from Descope session tokens without a lookup.
+const apiKeyPrefix = "bsskey_"
+
+// apiKeyShownPrefix is how many leading characters of a key are kept in the
+// clear to identify it.
+const apiKeyShownPrefix = len(apiKeyPrefix) + 6
+
+// APIKey is an admin-issued credential for tooling. Key is only set in the
+// response that creates it; afterwards only a hash is kept. A key acts in the
+// tenant it was created in, with its own permissions and, if PlayerID is set,
+// as that player.
+type APIKey struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Key         string     `json:"key,omitempty"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	PlayerID    string     `json:"player_id,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// contextKeyAPIKey holds the ID of the API key a request was made with.
+const contextKeyAPIKey contextKey = "apiKey"
+
+// apiKeyUserID is the actor recorded for requests made with key id.
+func apiKeyUserID(id int64) string {
+	return "apikey:" + strconv.FormatInt(id, 10)
+}
+
+// authenticateAPIKey returns ctx carrying the identity of key: its tenant,
+// permissions and player. selectedTenant is the X-Tenant-ID header, which may
+// only repeat the key's own tenant.
+func authenticateAPIKey(ctx context.Context, key, selectedTenant string) (context.Context, error) {
+	var id int64
+	var tenantID, playerID string
+	var permissions []string
+	var expiresAt sql.NullTime
+	query := `SELECT id, tenant_id, permissions, COALESCE(player_id, ''), expires_at FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL`
+	err := db.QueryRowContext(ctx, query, hashToken(key)).Scan(&id, &tenantID, pq.Array(&permissions), &playerID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUnauthorized("Invalid API key")
+	} else if err != nil {
+		return nil, errInternal("looking up API key", err)
+	}
+	if expiresAt.Valid && !time.Now().Before(expiresAt.Time) {
+		return nil, errUnauthorized("API key has expired")
+	}
+	if selectedTenant != "" && selectedTenant != tenantID {
+		return nil, errForbidden(codeForbidden, tenantIDHeader+" is not the API key's tenant")
+	}
+
+	// Keys are looked up on every request, so last_used_at only moves once
+	// a minute.
+	touch := `UPDATE api_keys SET last_used_at = now() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	if _, err := db.ExecContext(ctx, touch, id); err != nil {
+		slog.WarnContext(ctx, "updating API key last_used_at", "error", err)
+	}
+
+	// Permissions dropped from the server since the key was issued grant
+	// nothing, and a key is bound to a tenant, so it never holds
+	// projectPermissions.
+	g := grants{Permissions: make(map[string]bool)}
+	for _, p := range permissions {
+		if slices.Contains(allPermissions, p) && !slices.Contains(projectPermissions, p) {
+			g.Permissions[p] = true
+		}
+	}
+	ctx = withTenant(ctx, tenantID)
+	setLogPlayer(ctx, playerID, nil)
+	ctx = context.WithValue(ctx, contextKeyAPIKey, id)
+	ctx = context.WithValue(ctx, contextKeyUserID, apiKeyUserID(id))
+	ctx = context.WithValue(ctx, contextKeyPlayerID, playerID)
+	return context.WithValue(ctx, contextKeyGrants, g), nil
+}
+
+// apiKeyColumns are the columns scanned by scanAPIKey, in order.
+const apiKeyColumns = `id, name, prefix, permissions, COALESCE(player_id, ''), created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Permissions), &k.PlayerID, &k.CreatedBy, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	k.ExpiresAt = timePtr(expiresAt)
+	k.LastUsedAt = timePtr(lastUsedAt)
+	k.RevokedAt = timePtr(revokedAt)
+	if k.Permissions == nil {
+		k.Permissions = []string{}
+	}
+	return k, err
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// createAPIKey handles POST /api/admin/api-keys. A key can only be given
+// permissions its creator holds, and is bound to the creator's tenant. The
+// key itself is returned only in this response. Keys can't create keys, or a
+// short-lived key could outlive itself.
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.Context().Value(contextKeyAPIKey).(int64); ok {
+		writeError(w, r, errForbidden(codeForbidden, "API keys can't create API keys"))
+		return
+	}
+	var k APIKey
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" || len(k.Name) > 100 {
+		writeError(w, r, errValidation("name is required and may be at most 100 characters"))
+		return
+	}
+	if k.Permissions == nil {
+		k.Permissions = []string{}
+	}
+	for _, p := range k.Permissions {
+		if !slices.Contains(allPermissions, p) {
+			writeError(w, r, errValidation("unknown permission "+strconv.Quote(p)))
+			return
+		}
+		if slices.Contains(projectPermissions, p) {
+			writeError(w, r, errValidation("API keys can't hold the project-wide "+p+" permission"))
+			return
+		}
+		if !hasPermission(r.Context(), p) {
+			writeError(w, r, errForbidden(codeForbidden, "You can't grant the "+p+" permission, which you don't hold"))
+			return
+		}
+	}
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		writeError(w, r, errValidation("expires_at must be in the future"))
+		return
+	}
+	if k.PlayerID != "" {
+		if _, err := findPlayer(r.Context(), k.PlayerID); errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, errPlayerNotFound())
+			return
+		} else if err != nil {
+			writeError(w, r, errInternal("looking up player", err))
+			return
+		}
+	}
+
+	var b [32]byte
+	rand.Read(b[:])
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b[:])
+	createdBy, _ := r.Context().Value(contextKeyUserID).(string)
+
+	query := `INSERT INTO api_keys (key_hash, prefix, name, tenant_id, permissions, player_id, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8) RETURNING ` + apiKeyColumns
+	created, err := scanAPIKey(db.QueryRowContext(r.Context(), query, hashToken(key), key[:apiKeyShownPrefix], k.Name,
+		tenantFromContext(r.Context()), pq.Array(k.Permissions), k.PlayerID, createdBy, k.ExpiresAt))
+	if err != nil {
+		writeError(w, r, errInternal("creating API key", err))
+		return
+	}
+	created.Key = key
+
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+// listAPIKeys handles GET /api/admin/api-keys, listing the keys of the
+// admin's tenant, revoked ones included.
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE tenant_id = $1 ORDER BY id`
+	rows, err := db.QueryContext(r.Context(), query, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("retrieving API keys", err))
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			writeError(w, r, errInternal("scanning API key row", err))
+			return
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over API key rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, keys)
+}
+
+// revokeAPIKey handles DELETE /api/admin/api-keys/{id}. The key stops working
+// at once; its row is kept so past use can still be attributed.
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, errValidation("Invalid API key ID"))
+		return
+	}
+
+	query := `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL`
+	result, err := db.ExecContext(r.Context(), query, id, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("revoking API key", err))
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		writeError(w, r, errInternal("checking rows affected", err))
+		return
+	} else if n == 0 {
+		writeError(w, r, &apiError{Status: http.StatusNotFound, Code: codeAPIKeyNotFound, Detail: "API key not found or already revoked"})
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+}
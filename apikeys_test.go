@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// A key holding apikeys:manage can't mint another key, which could outlive it.
+func TestAPIKeyCannotCreateAPIKeys(t *testing.T) {
+	const key = apiKeyPrefix + "tooling"
+	mock := mockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL`)).
+		WithArgs(hashToken(key)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "permissions", "player_id", "expires_at"}).
+			AddRow(3, "tenant-a", pq.Array([]string{permAPIKeysManage}), "", nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET last_used_at`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAdminAudit(mock, "POST /api/admin/api-keys", "")
+
+	w := serve(http.MethodPost, "/api/admin/api-keys", key, strings.NewReader(`{"name":"forever","permissions":[]}`))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "can't create API keys") {
+		t.Fatalf("status %d, body %s; want 403 refusing the key", w.Code, w.Body)
+	}
+}
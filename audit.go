@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Audited checkpoint actions. Other admin routes are recorded by
+// auditMiddleware under their method and route template.
+const (
+	auditCheckpointRead   = "checkpoint.read"
+	auditCheckpointList   = "checkpoint.list"
+	auditCheckpointCreate = "checkpoint.create"
+	auditCheckpointUpdate = "checkpoint.update"
+	auditCheckpointDelete = "checkpoint.delete"
+)
+
+// maxAuditPage bounds the entries returned by one audit query.
+const maxAuditPage = 500
+
+// AuditEntry is one row of the admin audit log.
+type AuditEntry struct {
+	ID                 int64     `json:"id"`
+	ActorID            string    `json:"actor_id"`
+	Role               string    `json:"role"`
+	Action             string    `json:"action"`
+	TargetCheckpointID *int      `json:"target_checkpoint_id,omitempty"`
+	TargetPlayerID     string    `json:"target_player_id,omitempty"`
+	BeforeHash         string    `json:"before_hash,omitempty"`
+	AfterHash          string    `json:"after_hash,omitempty"`
+	RequestID          string    `json:"request_id,omitempty"`
+	OccurredAt         time.Time `json:"occurred_at"`
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx, so audit entries can be written
+// in the transaction of the change they record.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// recordAudit appends an entry for a privileged operation by the user in ctx,
+// or by the admin impersonating them. before
+// and after are the checkpoint's state around the change; nil means it did
+// not exist (or, for reads, was not changed).
+func recordAudit(ctx context.Context, ex execer, action string, checkpointID int, playerID string, before, after *Checkpoint) error {
+	actorID, _ := ctx.Value(contextKeyUserID).(string)
+	if adminID := impersonatorFromContext(ctx); adminID != "" {
+		actorID = adminID
+	}
+	var target sql.NullInt64
+	if checkpointID != 0 {
+		target = sql.NullInt64{Int64: int64(checkpointID), Valid: true}
+	}
+	query := `INSERT INTO admin_audit_log (actor_id, role, action, target_checkpoint_id, target_player_id, before_hash, after_hash, request_id, tenant_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9)`
+	_, err := ex.ExecContext(ctx, query, actorID, rolesString(ctx), action, target, playerID,
+		checkpointHash(before), checkpointHash(after), requestIDFromContext(ctx), tenantFromContext(ctx))
+	return err
+}
+
+// checkpointHash digests the fields of cp that an admin can change.
+func checkpointHash(cp *Checkpoint) string {
+	if cp == nil {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		Username       string `json:"user_name"`
+		CheckpointData string `json:"checkpoint_data"`
+		PlayerID       string `json:"player_id"`
+	}{cp.Username, cp.CheckpointData, cp.PlayerID})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lockCheckpoint reads checkpoint id of the request's tenant and game inside tx and
+// locks it until the transaction ends, so its audited before-state is the one
+// that was changed.
+func lockCheckpoint(ctx context.Context, tx *sql.Tx, id int) (*Checkpoint, error) {
+	cp := Checkpoint{ID: id}
+	query := `SELECT user_name, checkpoint_data, COALESCE(player_id, '') FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&cp.Username, &cp.CheckpointData, &cp.PlayerID); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// auditMiddleware records every request to the admin routes before it runs,
+// so refused and failed attempts are kept too; a request whose entry can't be
+// written fails instead. It must run after sessionValidationMiddleware.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Method + " " + r.URL.Path
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			action = r.Method + " " + tmpl
+		}
+		if err := recordAudit(r.Context(), db, action, 0, mux.Vars(r)["playerID"], nil, nil); err != nil {
+			writeError(w, r, errInternal("recording admin request", err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listAuditLog handles GET /api/admin/audit, newest first, showing only the
+// admin's tenant. It filters by
+// ?actor_id=, ?action=, ?player_id=, ?checkpoint_id=, ?since= and ?until=,
+// and pages with ?before_id= (the last ID of the previous page) and ?limit=.
+func listAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		checkpointID, beforeID *int64
+		since, until           time.Time
+		err                    error
+	)
+	for name, dst := range map[string]**int64{"checkpoint_id": &checkpointID, "before_id": &beforeID} {
+		if s := q.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				writeError(w, r, errValidation("Invalid "+name))
+				return
+			}
+			*dst = &n
+		}
+	}
+	if since, err = parseTimeBound(q.Get("since")); err != nil {
+		writeError(w, r, errValidation("since must be an RFC 3339 time or a YYYY-MM-DD date"))
+		return
+	}
+	if until, err = parseTimeBound(q.Get("until")); err != nil {
+		writeError(w, r, errValidation("until must be an RFC 3339 time or a YYYY-MM-DD date"))
+		return
+	}
+	limit := 100
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxAuditPage {
+			writeError(w, r, errValidation("limit must be between 1 and 500"))
+			return
+		}
+	}
+
+	query := `SELECT id, actor_id, role, action, target_checkpoint_id, COALESCE(target_player_id, ''),
+			COALESCE(before_hash, ''), COALESCE(after_hash, ''), COALESCE(request_id, ''), occurred_at
+		FROM admin_audit_log
+		WHERE ($1 = '' OR actor_id = $1) AND ($2 = '' OR action = $2) AND ($3 = '' OR target_player_id = $3)
+			AND ($4::BIGINT IS NULL OR target_checkpoint_id = $4)
+			AND ($5::timestamptz IS NULL OR occurred_at >= $5) AND ($6::timestamptz IS NULL OR occurred_at < $6)
+			AND ($7::BIGINT IS NULL OR id < $7) AND tenant_id = $9
+		ORDER BY id DESC LIMIT $8`
+	rows, err := db.QueryContext(r.Context(), query, q.Get("actor_id"), q.Get("action"), q.Get("player_id"),
+		checkpointID, nullTime(since), nullTime(until), beforeID, limit, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("retrieving audit log", err))
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.ActorID, &e.Role, &e.Action, &e.TargetCheckpointID, &e.TargetPlayerID,
+			&e.BeforeHash, &e.AfterHash, &e.RequestID, &e.OccurredAt)
+		if err != nil {
+			writeError(w, r, errInternal("scanning audit row", err))
+			return
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over audit rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectAdminAudit expects auditMiddleware to record action on playerID.
+func expectAdminAudit(mock sqlmock.Sqlmock, action, playerID string) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO admin_audit_log`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), action, nil, playerID, "", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// An admin request whose audit entry can't be written is refused before it
+// runs.
+func TestAdminRequestFailsWithoutAudit(t *testing.T) {
+	fakeProjectSession(t, "tok-project-admin", "user-p", adminRole)
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "", "user-p")
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO admin_audit_log`)).WillReturnError(errors.New("disk full"))
+	w := serve(http.MethodGet, "/api/admin/sessions/cache", "tok-project-admin", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", w.Code, w.Body)
+	}
+}
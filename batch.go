@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"studentbackendgosql/model"
+)
+
+// maxBatchOperations bounds the operations in one batch request.
+const maxBatchOperations = 100
+
+// batchCheckpoints handles POST /api/gamecheckpoints:batch. All operations run
+// in one transaction. In atomic mode the first failure rolls everything back
+// and is returned as the response's problem, with the operation's index in the
+// detail; in per_item mode each operation runs under its own savepoint and the
+// response lists every outcome. Players may only touch their own checkpoints.
+func batchCheckpoints(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
+	if !ok || playerID == "" {
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+		return
+	}
+	var req model.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = model.BatchModeAtomic
+	}
+	if req.Mode != model.BatchModeAtomic && req.Mode != model.BatchModePerItem {
+		writeError(w, r, errValidation(`mode must be "atomic" or "per_item"`))
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		writeError(w, r, errValidation(fmt.Sprintf("A batch must contain between 1 and %d operations", maxBatchOperations)))
+		return
+	}
+
+	ctx := r.Context()
+	results := make([]model.BatchResult, len(req.Operations))
+	var failed *apiError
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		for i, op := range req.Operations {
+			if req.Mode == model.BatchModePerItem {
+				if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+			}
+
+			cp, status, opErr := applyBatchOperation(ctx, tx, op, playerID)
+			if opErr != nil && opErr.Status >= http.StatusInternalServerError {
+				return opErr
+			}
+			if opErr != nil && req.Mode == model.BatchModeAtomic {
+				failed = &apiError{Status: opErr.Status, Code: opErr.Code, Detail: fmt.Sprintf("Operation %d: %s", i, opErr.Detail)}
+				return failed
+			}
+
+			if opErr != nil {
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+				problem := newProblem(r, opErr)
+				results[i] = model.BatchResult{Status: opErr.Status, Error: &problem}
+				continue
+			}
+			if req.Mode == model.BatchModePerItem {
+				if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+			}
+			results[i] = model.BatchResult{Status: status, Checkpoint: cp}
+		}
+		return nil
+	})
+	if failed != nil {
+		writeError(w, r, failed)
+		return
+	} else if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			apiErr = errInternal("running checkpoint batch", err)
+		}
+		writeError(w, r, apiErr)
+		return
+	}
+
+	// Checkpoints in the results carry their player like those of the
+	// single-item handlers.
+	var cps []Checkpoint
+	for _, res := range results {
+		if res.Checkpoint != nil {
+			cps = append(cps, *res.Checkpoint)
+		}
+	}
+	if err := attachPlayers(ctx, cps); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint players", err))
+		return
+	}
+	for i, j := 0, 0; i < len(results); i++ {
+		if results[i].Checkpoint != nil {
+			results[i].Checkpoint = &cps[j]
+			j++
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, model.BatchResponse{Results: results})
+}
+
+// applyBatchOperation runs one operation inside tx and returns the resulting
+// checkpoint and status. It never leaves the request's tenant, and without the
+// matching :any permission the operation is limited to playerID's checkpoints, as in the single-item handlers; changes
+// made with it are audited.
+func applyBatchOperation(ctx context.Context, tx *sql.Tx, op model.BatchOperation, playerID string) (*model.Checkpoint, int, *apiError) {
+	perm := permCheckpointsWriteAny
+	if op.Op == model.BatchOpDelete {
+		perm = permCheckpointsDeleteAny
+	}
+	admin := hasPermission(ctx, perm)
+	// owner is the player_id a row must have; empty allows any row.
+	owner := playerID
+	if admin {
+		owner = ""
+	}
+
+	switch op.Op {
+	case model.BatchOpCreate:
+		if op.Checkpoint == nil {
+			return nil, 0, errValidation("create needs a checkpoint")
+		}
+		cp := *op.Checkpoint
+		if !admin {
+			if cp.PlayerID != "" && cp.PlayerID != playerID {
+				return nil, 0, errForbidden(codeNotOwner, "Checkpoints can only be created for your own player")
+			}
+			cp.PlayerID = playerID
+		} else if err := checkPlayerTenant(ctx, tx, cp.PlayerID); err != nil {
+			return nil, 0, err
+		}
+		if err := checkCheckpointForGame(ctx, tx, cp, true); err != nil {
+			return nil, 0, err
+		}
+		query := `INSERT INTO gameplay_checkpoints (user_name, checkpoint_data, player_id, tenant_id, game_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_edited_at`
+		if err := tx.QueryRowContext(ctx, query, cp.Username, cp.CheckpointData, cp.PlayerID, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&cp.ID, &cp.CreatedAt, &cp.LastEditedAt); err != nil {
+			return nil, 0, errInternal("creating checkpoint", err)
+		}
+		if admin {
+			if err := recordAudit(ctx, tx, auditCheckpointCreate, cp.ID, cp.PlayerID, nil, &cp); err != nil {
+				return nil, 0, errInternal("auditing checkpoint create", err)
+			}
+		}
+		if err := writeOutboxEvent(ctx, tx, model.EventCheckpointCreated, cp); err != nil {
+			return nil, 0, errInternal("creating checkpoint", err)
+		}
+		return &cp, http.StatusCreated, nil
+
+	case model.BatchOpUpdate:
+		if op.Checkpoint == nil {
+			return nil, 0, errValidation("update needs a checkpoint")
+		}
+		cp := *op.Checkpoint
+		if op.ID == 0 || (cp.ID != 0 && cp.ID != op.ID) {
+			return nil, 0, errValidation("update needs an id matching the checkpoint's")
+		}
+		cp.ID = op.ID
+		before, opErr := lockForAudit(ctx, tx, op.ID, admin)
+		if opErr != nil {
+			return nil, 0, opErr
+		}
+		if err := checkCheckpointForGame(ctx, tx, cp, false); err != nil {
+			return nil, 0, err
+		}
+		query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3 AND ($4 = '' OR player_id = $4) AND tenant_id = $5 AND game_id = $6
+			RETURNING created_at, last_edited_at, COALESCE(player_id, '')`
+		err := tx.QueryRowContext(ctx, query, cp.Username, cp.CheckpointData, cp.ID, owner, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&cp.CreatedAt, &cp.LastEditedAt, &cp.PlayerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, errCheckpointNotFound()
+		} else if err != nil {
+			return nil, 0, errInternal("updating checkpoint", err)
+		}
+		if admin {
+			if err := recordAudit(ctx, tx, auditCheckpointUpdate, cp.ID, cp.PlayerID, before, &cp); err != nil {
+				return nil, 0, errInternal("auditing checkpoint update", err)
+			}
+		}
+		if err := writeOutboxEvent(ctx, tx, model.EventCheckpointUpdated, cp); err != nil {
+			return nil, 0, errInternal("updating checkpoint", err)
+		}
+		return &cp, http.StatusOK, nil
+
+	case model.BatchOpDelete:
+		if op.ID == 0 {
+			return nil, 0, errValidation("delete needs an id")
+		}
+		deleted := model.Checkpoint{ID: op.ID}
+		before, opErr := lockForAudit(ctx, tx, op.ID, admin)
+		if opErr != nil {
+			return nil, 0, opErr
+		}
+		query := `DELETE FROM gameplay_checkpoints WHERE id = $1 AND ($2 = '' OR player_id = $2) AND tenant_id = $3 AND game_id = $4 RETURNING COALESCE(player_id, '')`
+		err := tx.QueryRowContext(ctx, query, op.ID, owner, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&deleted.PlayerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, errCheckpointNotFound()
+		} else if err != nil {
+			return nil, 0, errInternal("deleting checkpoint", err)
+		}
+		if admin {
+			if err := recordAudit(ctx, tx, auditCheckpointDelete, deleted.ID, deleted.PlayerID, before, nil); err != nil {
+				return nil, 0, errInternal("auditing checkpoint delete", err)
+			}
+		}
+		if err := writeOutboxEvent(ctx, tx, model.EventCheckpointDeleted, deleted); err != nil {
+			return nil, 0, errInternal("deleting checkpoint", err)
+		}
+		return &deleted, http.StatusOK, nil
+	}
+	return nil, 0, errValidation(`op must be "create", "update" or "delete"`)
+}
+
+// lockForAudit returns the target checkpoint of a privileged change as it was
+// before, for the audit log. Players' own changes are not audited, so it
+// returns nil for them.
+func lockForAudit(ctx context.Context, tx *sql.Tx, id int, admin bool) (*model.Checkpoint, *apiError) {
+	if !admin {
+		return nil, nil
+	}
+	before, err := lockCheckpoint(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errCheckpointNotFound()
+	} else if err != nil {
+		return nil, errInternal("reading checkpoint", err)
+	}
+	return before, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"studentbackendgosql/model"
+)
+
+func TestBatchResultsCarryPlayers(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO gameplay_checkpoints`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "last_edited_at"}).AddRow(7, time.Now(), time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO checkpoint_outbox`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, display_name, avatar_url FROM players`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "display_name", "avatar_url"}).AddRow("tenant-a:user-a", "Ada", ""))
+
+	body := `{"operations":[{"op":"create","checkpoint":{"user_name":"old","checkpoint_data":"{}"}}]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/gamecheckpoints:batch", strings.NewReader(body)).
+		WithContext(playerContext("tenant-a", "user-a", "tenant-a:user-a"))
+	w := httptest.NewRecorder()
+	batchCheckpoints(w, r)
+
+	var resp model.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || len(resp.Results) != 1 {
+		t.Fatalf("status %d, %v: %+v", w.Code, err, resp)
+	}
+	cp := resp.Results[0].Checkpoint
+	if cp == nil || cp.Player == nil || cp.Player.DisplayName != "Ada" || cp.Username != "Ada" {
+		t.Errorf("result %+v", resp.Results[0])
+	}
+}
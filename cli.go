@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"studentbackendgosql/model"
+)
+
+// cliActor is the actor of audit entries written by maintenance commands.
+const cliActor = "cli"
+
+// runCommand runs a maintenance subcommand instead of the server and returns
+// the process exit code:
+//
+//	bssbackendgo export [-db ENV] [-tenant ID] [-game ID] [-format ndjson|csv] [-player ID] [-since T] [-until T] [-o FILE]
+//	bssbackendgo import [-db ENV] [-tenant ID] [-game ID] [-format ndjson|csv] [-preserve-ids] [-on-conflict skip|overwrite|renumber] [-dry-run] [FILE]
+//
+// -db names one of listOfDBConnections (default: the configured database), so
+// saves can be moved between databases by exporting from one and importing
+// into another. -tenant is the
+// Descope tenant whose checkpoints are read or written (default: none), and
+// -game the game (default bss); imports apply the game's size limit and schema.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "export":
+		return runExport(args[1:])
+	case "import":
+		return runImport(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q; expected export or import\n", args[0])
+	return 2
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbEnv := fs.String("db", config.Database, "environment variable holding the database connection string")
+	tenant := fs.String("tenant", "", "Descope tenant to export from")
+	game := fs.String("game", model.DefaultGameID, "game to export from")
+	format := fs.String("format", formatNDJSON, "output format: ndjson or csv")
+	player := fs.String("player", "", "only export this player's checkpoints")
+	since := fs.String("since", "", "only export checkpoints edited at or after this time (RFC 3339 or YYYY-MM-DD)")
+	until := fs.String("until", "", "only export checkpoints edited before this time (RFC 3339 or YYYY-MM-DD)")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	f := exportFilter{PlayerID: *player}
+	var err error
+	if f.Since, err = parseTimeBound(*since); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -since:", err)
+		return 2
+	}
+	if f.Until, err = parseTimeBound(*until); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -until:", err)
+		return 2
+	}
+
+	conn, err := openCommandDB(*dbEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	ctx, err := commandContext(conn, *tenant, *game)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	n, err := exportCheckpointsTo(ctx, conn, w, *format, f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d checkpoints\n", n)
+	return 0
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbEnv := fs.String("db", config.Database, "environment variable holding the database connection string")
+	tenant := fs.String("tenant", "", "Descope tenant to import into")
+	game := fs.String("game", model.DefaultGameID, "game to import into")
+	format := fs.String("format", formatNDJSON, "input format: ndjson or csv")
+	var opts importOptions
+	fs.BoolVar(&opts.PreserveIDs, "preserve-ids", false, "keep the IDs from the input")
+	fs.StringVar(&opts.OnConflict, "on-conflict", "skip", "when a preserved ID exists: skip, overwrite or renumber")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "report what would be imported without writing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	conn, err := openCommandDB(*dbEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, err := commandContext(conn, *tenant, *game)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report, err := importCheckpointsFrom(ctx, conn, r, *format, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	fmt.Fprintln(os.Stderr, describeImport(report))
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// openCommandDB connects to the database named by one of listOfDBConnections.
+func openCommandDB(env string) (*sql.DB, error) {
+	if !slices.Contains(listOfDBConnections, env) {
+		return nil, fmt.Errorf("-db must be one of %v", listOfDBConnections)
+	}
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		return nil, fmt.Errorf("%s is not set", env)
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to %s: %w", env, err)
+	}
+	return conn, nil
+}
+
+// commandContext is the context a command works in: tenant and game, with the
+// games loaded from conn so the game's rules apply. Audit entries written by a
+// command name cliActor as the actor.
+func commandContext(conn *sql.DB, tenant, game string) (context.Context, error) {
+	ctx := withTenant(context.WithValue(context.Background(), contextKeyUserID, cliActor), tenant)
+	if err := games.load(ctx, conn); err != nil {
+		return nil, fmt.Errorf("loading games: %w", err)
+	}
+	if _, ok := games.get(game); !ok {
+		return nil, fmt.Errorf("unknown game %q", game)
+	}
+	return withGame(ctx, game), nil
+}
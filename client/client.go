@@ -0,0 +1,347 @@
+// Package client is a typed Go client for the bss checkpoint API, for tooling
+// and bots that would otherwise hand-write HTTP requests.
+//
+//	c, err := client.New("https://bss.example.com", client.WithToken(sessionToken))
+//	cp, err := c.CreateCheckpoint(ctx, client.Checkpoint{Username: "ada", CheckpointData: data})
+//	if client.IsCode(err, model.CodeNotOwner) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"studentbackendgosql/model"
+)
+
+// Checkpoint is the checkpoint resource, shared with the server.
+type Checkpoint = model.Checkpoint
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+)
+
+// Client calls the checkpoint API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      func(context.Context) (string, error)
+	maxRetries int
+	backoff    time.Duration
+	game       string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests (default http.DefaultClient).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithToken sends token as the bearer session token on every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = func(context.Context) (string, error) { return token, nil }
+	}
+}
+
+// WithTokenFunc calls fn before every request to obtain the bearer token, for
+// callers that refresh sessions.
+func WithTokenFunc(fn func(context.Context) (string, error)) Option {
+	return func(c *Client) { c.token = fn }
+}
+
+// WithRetries sets how many times requests are retried after a network error
+// or a 429, 502, 503 or 504 response. GET, PUT and DELETE are idempotent; POST is
+// made so with an Idempotency-Key. Zero disables retries; the default is 3.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithBackoff sets the delay before the first retry; it doubles on each
+// further attempt, but is never shorter than a 429's Retry-After. The default
+// is 200ms.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) { c.backoff = d }
+}
+
+// WithGame makes the checkpoint calls work on the given game's checkpoints
+// instead of the default game's.
+func WithGame(game string) Option {
+	return func(c *Client) { c.game = game }
+}
+
+// New returns a Client for the API served at baseURL.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q must be absolute", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is returned for every non-2xx response. Problem holds the decoded
+// problem body; when the server did not send one, only Code and Detail are set.
+// RetryAfter is the response's Retry-After, if it had one.
+type Error struct {
+	StatusCode int
+	Problem    model.Problem
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("client: %d %s", e.StatusCode, e.Problem.Code)
+	if e.Problem.Detail != "" {
+		msg += ": " + e.Problem.Detail
+	}
+	if e.Problem.RequestID != "" {
+		msg += " (request " + e.Problem.RequestID + ")"
+	}
+	return msg
+}
+
+// IsCode reports whether err is an API error with the given problem code,
+// such as model.CodeCheckpointNotFound.
+func IsCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Problem.Code == code
+}
+
+// CreateCheckpoint creates cp and returns it as stored by the server. The
+// request carries a fresh Idempotency-Key, so it is retried like the
+// idempotent calls without risk of creating a second checkpoint.
+func (c *Client) CreateCheckpoint(ctx context.Context, cp Checkpoint) (*Checkpoint, error) {
+	var created Checkpoint
+	if err := c.do(ctx, http.MethodPost, c.checkpointsPath(), cp, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetCheckpoint returns the checkpoint with the given ID.
+func (c *Client) GetCheckpoint(ctx context.Context, id int) (*Checkpoint, error) {
+	var cp Checkpoint
+	if err := c.do(ctx, http.MethodGet, c.checkpointPath(id), nil, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// ListCheckpoints returns every checkpoint visible to the caller: their own, or
+// all of them for admins.
+func (c *Client) ListCheckpoints(ctx context.Context) ([]Checkpoint, error) {
+	var cps []Checkpoint
+	if err := c.do(ctx, http.MethodGet, c.checkpointsPath(), nil, &cps); err != nil {
+		return nil, err
+	}
+	return cps, nil
+}
+
+// UpdateCheckpoint replaces the name and data of the checkpoint with cp.ID.
+func (c *Client) UpdateCheckpoint(ctx context.Context, cp Checkpoint) error {
+	return c.do(ctx, http.MethodPut, c.checkpointPath(cp.ID), cp, nil)
+}
+
+// DeleteCheckpoint deletes the checkpoint with the given ID.
+func (c *Client) DeleteCheckpoint(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, c.checkpointPath(id), nil, nil)
+}
+
+// Batch runs several creates, updates and deletes in one transaction. In
+// model.BatchModeAtomic a failing operation fails the call and nothing is
+// applied; in model.BatchModePerItem the results report each outcome.
+func (c *Client) Batch(ctx context.Context, req model.BatchRequest) (*model.BatchResponse, error) {
+	var resp model.BatchResponse
+	if err := c.do(ctx, http.MethodPost, c.checkpointsPath()+":batch", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Profile returns the caller's player profile.
+func (c *Client) Profile(ctx context.Context) (*model.Player, error) {
+	var p model.Player
+	if err := c.do(ctx, http.MethodGet, "/api/players/me", nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateProfile changes the fields of the caller's profile that u sets and
+// returns the result.
+func (c *Client) UpdateProfile(ctx context.Context, u model.PlayerUpdate) (*model.Player, error) {
+	var p model.Player
+	if err := c.do(ctx, http.MethodPatch, "/api/players/me", u, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// checkpointsPath is the checkpoint collection of the client's game.
+func (c *Client) checkpointsPath() string {
+	if c.game == "" {
+		return "/api/gamecheckpoints"
+	}
+	return "/api/games/" + url.PathEscape(c.game) + "/checkpoints"
+}
+
+func (c *Client) checkpointPath(id int) string {
+	return c.checkpointsPath() + "/" + strconv.Itoa(id)
+}
+
+// do sends one API call, retrying idempotent methods, and decodes a
+// successful JSON response into out when out is non-nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("client: encoding request: %w", err)
+		}
+	}
+
+	var idempotencyKey string
+	if method == http.MethodPost || method == http.MethodPatch {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	attempts := 1
+	if isIdempotent(method) || idempotencyKey != "" {
+		attempts += c.maxRetries
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff << (attempt - 1)
+			var apiErr *Error
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+				delay = apiErr.RetryAfter
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		var retry bool
+		retry, err = c.send(ctx, method, path, idempotencyKey, body, out)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send performs a single attempt and reports whether a failure may be retried.
+func (c *Client) send(ctx context.Context, method, path, idempotencyKey string, body []byte, out any) (bool, error) {
+	u := c.baseURL.JoinPath(path)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return false, fmt.Errorf("client: building request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return false, fmt.Errorf("client: obtaining token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("client: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// 409 idempotency_in_progress means the first attempt is still running.
+		retry := resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout
+		apiErr := decodeError(resp)
+		retry = retry || (idempotencyKey != "" && IsCode(apiErr, model.CodeIdempotencyInProgress))
+		return retry, apiErr
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("client: decoding response: %w", err)
+	}
+	return false, nil
+}
+
+// decodeError maps an error response to *Error, falling back to the status
+// text when the body is not a problem document.
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), model.ProblemContentType) &&
+		json.Unmarshal(data, &apiErr.Problem) == nil {
+		return apiErr
+	}
+	apiErr.Problem = model.Problem{
+		Status: resp.StatusCode,
+		Title:  http.StatusText(resp.StatusCode),
+		Detail: strings.TrimSpace(string(data)),
+	}
+	return apiErr
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// newIdempotencyKey returns a random key shared by all attempts of one call.
+func newIdempotencyKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,99 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"studentbackendgosql/client"
+	"studentbackendgosql/model"
+)
+
+func TestCreateCheckpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/gamecheckpoints" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if r.Header.Get("Idempotency-Key") == "" {
+			t.Error("no Idempotency-Key")
+		}
+		var cp client.Checkpoint
+		if err := json.NewDecoder(r.Body).Decode(&cp); err != nil {
+			t.Error(err)
+		}
+		cp.ID = 42
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(cp)
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.WithToken("tok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := c.CreateCheckpoint(context.Background(), client.Checkpoint{Username: "ada", CheckpointData: "level 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.ID != 42 || cp.CheckpointData != "level 1" {
+		t.Errorf("checkpoint = %+v", cp)
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/games/g1/checkpoints/7" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", model.ProblemContentType)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.Problem{Status: http.StatusNotFound, Title: "Not Found", Code: model.CodeCheckpointNotFound, Detail: "Checkpoint not found", RequestID: "req-1"})
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.WithGame("g1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetCheckpoint(context.Background(), 7)
+	if !client.IsCode(err, model.CodeCheckpointNotFound) {
+		t.Fatalf("err = %v, want %s", err, model.CodeCheckpointNotFound)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Problem.RequestID != "req-1" {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestRetriesUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.WithBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListCheckpoints(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("calls = %d, want 2", n)
+	}
+}
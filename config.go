@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// defaultConfigFile is read when CONFIG_FILE is unset, if it exists.
+const defaultConfigFile = "config.json"
+
+// authProviderDescope is the only supported auth provider.
+const authProviderDescope = "descope"
+
+// serverConfig is the server's configuration: a JSON file named by
+// CONFIG_FILE, then environment variables, over built-in defaults.
+type serverConfig struct {
+	// Port is the HTTP listen port (PORT).
+	Port string `json:"port"`
+	// Database names the environment variable, one of listOfDBConnections,
+	// holding the connection string (DATABASE).
+	Database     string               `json:"database"`
+	Auth         authConfig           `json:"auth"`
+	CORS         corsConfig           `json:"cors"`
+	TLS          tlsSettings          `json:"tls"`
+	HTTP         httpSettings         `json:"http"`
+	SessionCache sessionCacheSettings `json:"session_cache"`
+	Idempotency  idempotencySettings  `json:"idempotency"`
+	Events       eventSettings        `json:"events"`
+	RateLimits   rateLimitConfig      `json:"rate_limits"`
+}
+
+type authConfig struct {
+	// Provider validates sessions (AUTH_PROVIDER); only "descope" exists.
+	Provider string `json:"provider"`
+	// ProjectID is the Descope project (DESCOPE_PROJECT_BSS_ID), required
+	// by the server but not by maintenance commands.
+	ProjectID string `json:"project_id"`
+	// RolePermissions maps each Descope role to the permissions it grants,
+	// replacing the default mapping (ROLE_PERMISSIONS, as JSON).
+	RolePermissions map[string][]string `json:"role_permissions"`
+	// PlayerIDClaim names the custom session claim holding the player ID for
+	// an account's default profile (PLAYER_ID_CLAIM). When unset or absent
+	// from the token, the user ID is used.
+	PlayerIDClaim string `json:"player_id_claim"`
+}
+
+// corsConfig is the cross-origin policy. Headers the API itself reads and
+// writes are always allowed and exposed; AllowedHeaders adds to them.
+type corsConfig struct {
+	// AllowedOrigins are exact origins, origins with * wildcards, each
+	// matching one or more characters other than "." and "/" (e.g.
+	// https://studentfrontendreact-*.vercel.app), and regular expressions
+	// prefixed with "regex:", matched against the whole origin
+	// (CORS_ALLOWED_ORIGINS, comma-separated).
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods (CORS_ALLOWED_METHODS, comma-separated).
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders (CORS_ALLOWED_HEADERS, comma-separated).
+	AllowedHeaders []string `json:"allowed_headers"`
+}
+
+// tlsSettings turn on TLS when CertFile and KeyFile are set; without them the
+// server speaks plain HTTP and expects a proxy in front.
+type tlsSettings struct {
+	// CertFile and KeyFile are PEM files, reloaded when they change
+	// (TLS_CERT_FILE, TLS_KEY_FILE).
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile, if set, is a PEM bundle of CAs whose client certificates
+	// are required on /api/admin routes and for using admin permissions or
+	// impersonation anywhere (TLS_CLIENT_CA_FILE).
+	ClientCAFile string `json:"client_ca_file"`
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds sent
+	// over TLS; 0 sends none (HSTS_MAX_AGE).
+	HSTSMaxAge int `json:"hsts_max_age"`
+}
+
+func (t tlsSettings) enabled() bool {
+	return t.CertFile != ""
+}
+
+// httpSettings bound how long a connection may take over each part of an
+// exchange, so slow or idle clients can't hold connections open. There is no
+// write timeout: event streams and WebSockets stay open for as long as the
+// client listens.
+type httpSettings struct {
+	// ReadHeaderTimeout bounds reading a request's headers
+	// (HTTP_READ_HEADER_TIMEOUT).
+	ReadHeaderTimeout duration `json:"read_header_timeout"`
+	// ReadTimeout bounds reading a whole request, body included, so it must
+	// allow for the largest import (HTTP_READ_TIMEOUT).
+	ReadTimeout duration `json:"read_timeout"`
+	// IdleTimeout bounds how long a keep-alive connection waits for its next
+	// request (HTTP_IDLE_TIMEOUT).
+	IdleTimeout duration `json:"idle_timeout"`
+}
+
+// sessionCacheSettings size the cache of validated session tokens.
+type sessionCacheSettings struct {
+	// TTL is how long a session is cached; 0 turns the cache off
+	// (SESSION_CACHE_TTL).
+	TTL duration `json:"ttl"`
+	// Size is how many sessions are cached at most (SESSION_CACHE_SIZE).
+	Size int `json:"size"`
+}
+
+type idempotencySettings struct {
+	// KeyTTL is how long a response is kept for replay (IDEMPOTENCY_KEY_TTL).
+	KeyTTL duration `json:"key_ttl"`
+}
+
+type eventSettings struct {
+	// BufferSize is how many recent events are kept for Last-Event-ID
+	// resumption (SSE_BUFFER_SIZE).
+	BufferSize int `json:"buffer_size"`
+}
+
+// duration is a time.Duration written as a Go duration string, e.g. "90s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"90s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// parseDurationEnv sets *d from the environment variable name, if it is set.
+func parseDurationEnv(name string, d *duration) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a duration such as 90s", name, s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// parseBoolEnv sets *b from the environment variable name, if it is set.
+func parseBoolEnv(name string, b *bool) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not true or false", name, s)
+	}
+	*b = v
+	return nil
+}
+
+// parseIntEnv sets *n from the environment variable name, if it is set.
+func parseIntEnv(name string, n *int) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a whole number", name, s)
+	}
+	*n = v
+	return nil
+}
+
+// defaultConfig is the configuration without a file or overrides.
+var defaultConfig = serverConfig{
+	Port:     "8080",
+	Database: listOfDBConnections[3],
+	Auth:     authConfig{Provider: authProviderDescope, RolePermissions: defaultRolePermissions},
+	CORS: corsConfig{
+		AllowedOrigins: []string{
+			"https://studentfrontendreact-git-test-point-conrad1451s-projects.vercel.app",
+			"https://studentfrontendreact.vercel.app",
+			"http://localhost:5173",
+			"http://localhost:5174",
+		},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	},
+	TLS: tlsSettings{HSTSMaxAge: 365 * 24 * 60 * 60},
+	HTTP: httpSettings{
+		ReadHeaderTimeout: duration(10 * time.Second),
+		ReadTimeout:       duration(2 * time.Minute),
+		IdleTimeout:       duration(2 * time.Minute),
+	},
+	SessionCache: sessionCacheSettings{TTL: duration(time.Minute), Size: 10000},
+	Idempotency:  idempotencySettings{KeyTTL: duration(24 * time.Hour)},
+	Events:       eventSettings{BufferSize: 1000},
+	RateLimits:   defaultRateLimits,
+}
+
+// config is the configuration in effect. Only its CORS origins change after
+// startup, through corsOrigins.
+var config = defaultConfig
+
+// loadConfig reads the configuration file and environment overrides and
+// validates the result, reporting every problem at once.
+func loadConfig() (serverConfig, error) {
+	c := defaultConfig
+	c.CORS.AllowedOrigins = slices.Clone(c.CORS.AllowedOrigins)
+	c.CORS.AllowedMethods = slices.Clone(c.CORS.AllowedMethods)
+	// A configured role mapping and route limits replace the built-in ones
+	// rather than adding to them; they are restored below if none are
+	// configured.
+	c.RateLimits.Routes = nil
+	c.Auth.RolePermissions = nil
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = defaultConfigFile
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && os.Getenv("CONFIG_FILE") == "":
+		// The default file is optional.
+	case err != nil:
+		return serverConfig{}, fmt.Errorf("reading config file: %w", err)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return serverConfig{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if s := os.Getenv("PORT"); s != "" {
+		c.Port = s
+	}
+	if s := os.Getenv("DATABASE"); s != "" {
+		c.Database = s
+	}
+	if s := os.Getenv("AUTH_PROVIDER"); s != "" {
+		c.Auth.Provider = s
+	}
+	if s := os.Getenv("DESCOPE_PROJECT_BSS_ID"); s != "" {
+		c.Auth.ProjectID = s
+	}
+	if s := os.Getenv("PLAYER_ID_CLAIM"); s != "" {
+		c.Auth.PlayerIDClaim = s
+	}
+	if s := os.Getenv("CORS_ALLOWED_ORIGINS"); s != "" {
+		c.CORS.AllowedOrigins = splitList(s)
+	}
+	if s := os.Getenv("CORS_ALLOWED_METHODS"); s != "" {
+		c.CORS.AllowedMethods = splitList(s)
+	}
+	if s := os.Getenv("CORS_ALLOWED_HEADERS"); s != "" {
+		c.CORS.AllowedHeaders = splitList(s)
+	}
+	if s := os.Getenv("TLS_CERT_FILE"); s != "" {
+		c.TLS.CertFile = s
+	}
+	if s := os.Getenv("TLS_KEY_FILE"); s != "" {
+		c.TLS.KeyFile = s
+	}
+	if s := os.Getenv("TLS_CLIENT_CA_FILE"); s != "" {
+		c.TLS.ClientCAFile = s
+	}
+	if s := os.Getenv("RATE_LIMIT_STORE"); s != "" {
+		c.RateLimits.Store = s
+	}
+	if err := errors.Join(
+		parseIntEnv("HSTS_MAX_AGE", &c.TLS.HSTSMaxAge),
+		parseDurationEnv("HTTP_READ_HEADER_TIMEOUT", &c.HTTP.ReadHeaderTimeout),
+		parseDurationEnv("HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout),
+		parseDurationEnv("HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout),
+		parseDurationEnv("SESSION_CACHE_TTL", &c.SessionCache.TTL),
+		parseIntEnv("SESSION_CACHE_SIZE", &c.SessionCache.Size),
+		parseDurationEnv("IDEMPOTENCY_KEY_TTL", &c.Idempotency.KeyTTL),
+		parseIntEnv("SSE_BUFFER_SIZE", &c.Events.BufferSize),
+		parseRateLimitsEnv(&c.RateLimits),
+		parseBoolEnv("TRUST_FORWARDED_FOR", &c.RateLimits.TrustForwardedFor),
+		parseRolePermissionsEnv(&c.Auth.RolePermissions),
+	); err != nil {
+		return serverConfig{}, err
+	}
+	if c.Auth.RolePermissions == nil {
+		c.Auth.RolePermissions = defaultRolePermissions
+	}
+	if c.RateLimits.Routes == nil {
+		c.RateLimits.Routes = defaultRateLimits.Routes
+	}
+
+	if err := c.validate(); err != nil {
+		return serverConfig{}, err
+	}
+	return c, nil
+}
+
+// splitList splits a comma-separated environment variable.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+var (
+	corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	headerName  = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+	// registeredClaims are JWT claims that never hold a player ID.
+	registeredClaims = []string{"iss", "aud", "exp", "nbf", "iat", "jti"}
+)
+
+// validate reports every invalid setting, each on its own line.
+func (c serverConfig) validate() error {
+	var errs []error
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("port: %q is not a port number", c.Port))
+	}
+	if !slices.Contains(listOfDBConnections, c.Database) {
+		errs = append(errs, fmt.Errorf("database: %q must be one of %v", c.Database, listOfDBConnections))
+	}
+	if c.Auth.Provider != authProviderDescope {
+		errs = append(errs, fmt.Errorf("auth.provider: %q is not supported; the only provider is %q", c.Auth.Provider, authProviderDescope))
+	}
+	errs = append(errs, validateRolePermissions(c.Auth.RolePermissions)...)
+	if claim := c.Auth.PlayerIDClaim; strings.ContainsFunc(claim, unicode.IsSpace) || slices.Contains(registeredClaims, claim) {
+		errs = append(errs, fmt.Errorf("auth.player_id_claim: %q is not a custom claim name", claim))
+	}
+	if _, err := newOriginMatcher(c.CORS.AllowedOrigins); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.CORS.AllowedMethods) == 0 {
+		errs = append(errs, errors.New("cors.allowed_methods: required"))
+	}
+	for i, m := range c.CORS.AllowedMethods {
+		if !slices.Contains(corsMethods, m) {
+			errs = append(errs, fmt.Errorf("cors.allowed_methods[%d]: %q must be one of %v", i, m, corsMethods))
+		}
+	}
+	for i, h := range c.CORS.AllowedHeaders {
+		if !headerName.MatchString(h) {
+			errs = append(errs, fmt.Errorf("cors.allowed_headers[%d]: %q is not a header name", i, h))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.enabled() {
+		errs = append(errs, errors.New("tls.client_ca_file: requires cert_file and key_file"))
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("tls.hsts_max_age: %d must not be negative", c.TLS.HSTSMaxAge))
+	}
+	for _, t := range []struct {
+		name string
+		d    duration
+	}{
+		{"http.read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"http.read_timeout", c.HTTP.ReadTimeout},
+		{"http.idle_timeout", c.HTTP.IdleTimeout},
+	} {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: %v must be positive", t.name, time.Duration(t.d)))
+		}
+	}
+	if c.SessionCache.TTL < 0 {
+		errs = append(errs, fmt.Errorf("session_cache.ttl: %v must not be negative", time.Duration(c.SessionCache.TTL)))
+	}
+	if c.SessionCache.Size <= 0 {
+		errs = append(errs, fmt.Errorf("session_cache.size: %d must be positive", c.SessionCache.Size))
+	}
+	if c.Idempotency.KeyTTL <= 0 {
+		errs = append(errs, fmt.Errorf("idempotency.key_ttl: %v must be positive", time.Duration(c.Idempotency.KeyTTL)))
+	}
+	if c.Events.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("events.buffer_size: %d must be positive", c.Events.BufferSize))
+	}
+	errs = append(errs, c.RateLimits.validate()...)
+	return errors.Join(errs...)
+}
+
+// originMatcher decides whether an origin is in the CORS allow-list.
+type originMatcher struct {
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// newOriginMatcher compiles the allowed_origins entries.
+func newOriginMatcher(origins []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]bool)}
+	var errs []error
+	for i, o := range origins {
+		switch {
+		case o == "*":
+			errs = append(errs, fmt.Errorf("cors.allowed_origins[%d]: allowing every origin is not supported; list them or use a pattern", i))
+		case strings.HasPrefix(o, "regex:"):
+			expr := strings.TrimPrefix(o, "regex:")
+			if _, err := regexp.Compile(expr); err != nil {
+				errs = append(errs, fmt.Errorf("cors.allowed_origins[%d]: %w", i, err))
+				continue
+			}
+			m.patterns = append(m.patterns, regexp.MustCompile("^(?:"+expr+")$"))
+		case strings.Contains(o, "*"):
+			if !strings.Contains(o, "://") {
+				errs = append(errs, fmt.Errorf("cors.allowed_origins[%d]: %q has no scheme", i, o))
+				continue
+			}
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(o), `\*`, `[^./]+`)
+			m.patterns = append(m.patterns, regexp.MustCompile("^"+pattern+"$"))
+		default:
+			if !isOrigin(o) {
+				errs = append(errs, fmt.Errorf("cors.allowed_origins[%d]: %q is not an origin like https://example.com", i, o))
+				continue
+			}
+			m.exact[o] = true
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return m, nil
+}
+
+// isOrigin reports whether o is an origin as browsers send it: a scheme and a
+// host, with an optional port, and nothing after them.
+func isOrigin(o string) bool {
+	u, err := url.Parse(o)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Opaque == "" && u.User == nil &&
+		u.Path == "" && !u.ForceQuery && u.RawQuery == "" && u.Fragment == "" && !strings.HasSuffix(o, "#")
+}
+
+func (m *originMatcher) allows(origin string) bool {
+	if m.exact[origin] {
+		return true
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOrigins holds the server-wide origins, replaced on SIGHUP.
+var corsOrigins atomic.Pointer[originMatcher]
+
+// setCORSOrigins makes c's origins the server-wide ones. c has been
+// validated, so they compile.
+func setCORSOrigins(c serverConfig) {
+	m, _ := newOriginMatcher(c.CORS.AllowedOrigins)
+	corsOrigins.Store(m)
+}
+
+// allowsServerOrigin reports whether origin is one of the server-wide origins.
+func allowsServerOrigin(origin string) bool {
+	m := corsOrigins.Load()
+	return m != nil && m.allows(origin)
+}
+
+// reloadCORSOnHangup reloads the configuration on every SIGHUP and applies its
+// CORS origins. Other settings need a restart. An invalid configuration is
+// logged and the origins in effect are kept.
+func reloadCORSOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		c, err := loadConfig()
+		if err != nil {
+			slog.Error("not reloading configuration", "error", err)
+			continue
+		}
+		setCORSOrigins(c)
+		slog.Info("reloaded CORS origins", "origins", c.CORS.AllowedOrigins)
+	}
+}
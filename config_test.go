@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigHTTPTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"http": {"read_timeout": "5m"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("HTTP_IDLE_TIMEOUT", "30s")
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(":0", nil, nil, c.HTTP)
+	if srv.ReadHeaderTimeout != 10*time.Second || srv.ReadTimeout != 5*time.Minute || srv.IdleTimeout != 30*time.Second {
+		t.Errorf("timeouts = %v, %v, %v; want the default, the file's and the environment's",
+			srv.ReadHeaderTimeout, srv.ReadTimeout, srv.IdleTimeout)
+	}
+}
+
+func TestLoadConfigRejectsBadTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"http": {"idle_timeout": "0s"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "http.idle_timeout") {
+		t.Errorf("zero idle timeout: error %v", err)
+	}
+
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "HTTP_READ_TIMEOUT") {
+		t.Errorf("unparsable read timeout: error %v", err)
+	}
+}
+
+func TestLoadConfigRejectsBadSettings(t *testing.T) {
+	for _, tc := range []struct{ env, value, want string }{
+		{"HSTS_MAX_AGE", "a year", "HSTS_MAX_AGE"},
+		{"HSTS_MAX_AGE", "-1", "tls.hsts_max_age"},
+		{"SESSION_CACHE_TTL", "-1m", "session_cache.ttl"},
+		{"SESSION_CACHE_SIZE", "lots", "SESSION_CACHE_SIZE"},
+		{"SESSION_CACHE_SIZE", "0", "session_cache.size"},
+		{"IDEMPOTENCY_KEY_TTL", "0s", "idempotency.key_ttl"},
+		{"SSE_BUFFER_SIZE", "0", "events.buffer_size"},
+		{"RATE_LIMITS", "fast", "RATE_LIMITS"},
+		{"RATE_LIMITS", `{"player": {"rate": 0}}`, "rate_limits.player"},
+		{"RATE_LIMITS", `{"routes": {"/api/players/me": {"ip": {"rate": 1, "burst": 1}}}}`, "rate_limits.routes"},
+		{"RATE_LIMIT_STORE", "redis", "rate_limits.store"},
+		{"TRUST_FORWARDED_FOR", "yes please", "TRUST_FORWARDED_FOR"},
+		{"ROLE_PERMISSIONS", `["Support"]`, "ROLE_PERMISSIONS"},
+		{"ROLE_PERMISSIONS", `{"Support": ["checkpoints:read:all"]}`, "auth.role_permissions"},
+		{"PLAYER_ID_CLAIM", "player id", "auth.player_id_claim"},
+		{"PLAYER_ID_CLAIM", "exp", "auth.player_id_claim"},
+	} {
+		t.Run(tc.env+"="+tc.value, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(`{}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+			t.Setenv(tc.env, tc.value)
+			if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v does not mention %s", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"studentbackendgosql/model"
+)
+
+// Error codes returned in the "code" member of every problem response; see
+// model for their contract.
+const (
+	codeUnauthorized       = model.CodeUnauthorized
+	codeForbidden          = model.CodeForbidden
+	codeNotOwner           = model.CodeNotOwner
+	codeValidationFailed   = model.CodeValidationFailed
+	codeCheckpointNotFound = model.CodeCheckpointNotFound
+	codeWebhookNotFound    = model.CodeWebhookNotFound
+	codeAPIKeyNotFound     = model.CodeAPIKeyNotFound
+	codePlayerNotFound     = model.CodePlayerNotFound
+	codeGameNotFound       = model.CodeGameNotFound
+	codeGameExists         = model.CodeGameExists
+	codeGameInUse          = model.CodeGameInUse
+	codeUnsupportedSchema  = model.CodeUnsupportedSchema
+	codeRouteNotFound      = model.CodeRouteNotFound
+	codeMethodNotAllowed   = model.CodeMethodNotAllowed
+	codeQuotaExceeded      = model.CodeQuotaExceeded
+	codeRateLimited        = model.CodeRateLimited
+
+	codePlayerHasCheckpoints  = model.CodePlayerHasCheckpoints
+	codeIdempotencyKeyReused  = model.CodeIdempotencyKeyReused
+	codeIdempotencyInProgress = model.CodeIdempotencyInProgress
+	codeInternal              = model.CodeInternal
+)
+
+// problemTypePrefix namespaces the "type" URI of each problem by its code.
+const problemTypePrefix = "urn:bssbackendgo:problem:"
+
+// apiError is the single error type handlers return to clients. Detail is safe
+// to show to the player; Err holds the internal cause, which is logged but
+// never written to the response.
+type apiError struct {
+	Status int
+	Code   string
+	Detail string
+	Err    error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Detail, e.Err)
+	}
+	return e.Code + ": " + e.Detail
+}
+
+func (e *apiError) Unwrap() error { return e.Err }
+
+func errUnauthorized(detail string) *apiError {
+	return &apiError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Detail: detail}
+}
+
+func errForbidden(code, detail string) *apiError {
+	return &apiError{Status: http.StatusForbidden, Code: code, Detail: detail}
+}
+
+func errValidation(detail string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Detail: detail}
+}
+
+// errBadBody reports a checkpoint request body that could not be decoded.
+func errBadBody(err error) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Detail: "Request body is not a valid checkpoint: " + err.Error()}
+}
+
+// errBadJSON reports a request body, other than a checkpoint, that could not
+// be decoded.
+func errBadJSON(err error) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Detail: "Request body is not valid JSON: " + err.Error()}
+}
+
+func errCheckpointNotFound() *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codeCheckpointNotFound, Detail: "Checkpoint not found"}
+}
+
+func errPlayerNotFound() *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codePlayerNotFound, Detail: "Player not found"}
+}
+
+func errGameNotFound() *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codeGameNotFound, Detail: "Game not found"}
+}
+
+// errInternal hides err from the client behind a generic message; what
+// describes the failed operation for the logs.
+func errInternal(what string, err error) *apiError {
+	return &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Detail: "An internal error occurred", Err: fmt.Errorf("%s: %w", what, err)}
+}
+
+// writeError renders err as a problem response. Errors that are not an
+// *apiError are treated as internal. Server errors are logged with their cause.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = errInternal("unhandled error", err)
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "code", apiErr.Code, "error", apiErr.Err)
+		recordSpanError(trace.SpanFromContext(r.Context()), apiErr)
+	} else {
+		slog.DebugContext(r.Context(), "request rejected", "code", apiErr.Code, "detail", apiErr.Detail, "error", apiErr.Err)
+	}
+
+	w.Header().Set("Content-Type", model.ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(newProblem(r, apiErr))
+}
+
+// newProblem builds the problem body for apiErr.
+func newProblem(r *http.Request, apiErr *apiError) model.Problem {
+	return model.Problem{
+		Type:      problemTypePrefix + apiErr.Code,
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Detail,
+		Instance:  r.URL.Path,
+		Code:      apiErr.Code,
+		RequestID: requestIDFromContext(r.Context()),
+	}
+}
+
+// notFoundHandler and methodNotAllowedHandler give unmatched routes the same
+// problem body as handler errors.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, &apiError{Status: http.StatusNotFound, Code: codeRouteNotFound, Detail: "No route matches " + r.URL.Path})
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, &apiError{Status: http.StatusMethodNotAllowed, Code: codeMethodNotAllowed, Detail: r.Method + " is not supported on " + r.URL.Path})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"studentbackendgosql/model"
+)
+
+// eventSink receives checkpoint lifecycle events from the outbox dispatcher.
+// Events arrive in outbox order, so a player's events are never reordered.
+type eventSink interface {
+	Publish(ctx context.Context, ev model.CheckpointEvent) error
+}
+
+const (
+	// outboxChannel is the PostgreSQL NOTIFY channel that wakes dispatchers
+	// as soon as a mutation commits.
+	outboxChannel = "checkpoint_outbox"
+	// outboxPollInterval is the fallback poll when no notification arrives.
+	outboxPollInterval = 5 * time.Second
+	// outboxBatchSize bounds the rows read per pass.
+	outboxBatchSize = 200
+	// outboxLockKey is the advisory lock that elects the one instance which
+	// delivers to durable sinks, keeping per-player ordering across instances.
+	outboxLockKey = 0x6273735f6f7574 // "bss_out"
+	// outboxRetention is how long dispatched rows are kept before the
+	// dispatcher prunes them.
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// writeOutboxEvent records an event for cp, in ctx's tenant and game, inside tx. Call
+// it in the same transaction as the mutation it describes.
+func writeOutboxEvent(ctx context.Context, tx *sql.Tx, eventType string, cp Checkpoint) error {
+	payload, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	var id int64
+	query := `INSERT INTO checkpoint_outbox (event_type, player_id, checkpoint_id, payload, tenant_id, game_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, eventType, cp.PlayerID, cp.ID, payload, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&id); err != nil {
+		return fmt.Errorf("writing outbox event: %w", err)
+	}
+	// NOTIFY is delivered on commit, and not at all if the transaction rolls back.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, outboxChannel, fmt.Sprint(id)); err != nil {
+		return fmt.Errorf("notifying outbox dispatchers: %w", err)
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction, committing if it returns nil.
+func withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// outboxDispatcher delivers committed outbox rows to sinks.
+//
+// Durable sinks (webhooks) get every event at least once: one instance, elected
+// with an advisory lock, delivers pending rows in id order and marks them
+// dispatched only after every durable sink accepted them. A failing sink holds
+// back that player's later events until it succeeds.
+//
+// Local sinks (WebSocket and SSE clients connected to this process) are fed
+// by every instance from its own cursor over the outbox, starting at the rows
+// committed after startup. They are best-effort: a row whose transaction
+// commits after a higher id was already read is skipped.
+type outboxDispatcher struct {
+	db  *sql.DB
+	dsn string
+
+	mu      sync.RWMutex
+	durable []eventSink
+	local   []eventSink
+
+	leader    *sql.Conn
+	cursor    int64
+	lastPrune time.Time
+}
+
+var events *outboxDispatcher
+
+func newOutboxDispatcher(db *sql.DB, dsn string) *outboxDispatcher {
+	return &outboxDispatcher{db: db, dsn: dsn}
+}
+
+// Register adds a durable sink that must accept each event at least once.
+func (d *outboxDispatcher) Register(s eventSink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.durable = append(d.durable, s)
+}
+
+// RegisterLocal adds an in-process sink fed on every instance.
+func (d *outboxDispatcher) RegisterLocal(s eventSink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.local = append(d.local, s)
+}
+
+// run dispatches until ctx is cancelled, waking on NOTIFY or every poll interval.
+func (d *outboxDispatcher) run(ctx context.Context) error {
+	if err := d.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM checkpoint_outbox`).Scan(&d.cursor); err != nil {
+		return fmt.Errorf("reading outbox position: %w", err)
+	}
+
+	listener := pq.NewListener(d.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("outbox listener", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(outboxChannel); err != nil {
+		slog.Warn("outbox listener unavailable, polling only", "error", err)
+	}
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.dispatchLocal(ctx); err != nil {
+			slog.Error("dispatching outbox to local sinks", "error", err)
+		}
+		if err := d.dispatchDurable(ctx); err != nil {
+			slog.Error("dispatching outbox to durable sinks", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			d.releaseLeadership()
+			return ctx.Err()
+		case <-listener.Notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchLocal feeds this process's sinks every row after the cursor.
+func (d *outboxDispatcher) dispatchLocal(ctx context.Context) error {
+	for {
+		evs, err := d.load(ctx, `SELECT id, event_type, player_id, tenant_id, game_id, payload, created_at FROM checkpoint_outbox
+			WHERE id > $1 ORDER BY id LIMIT $2`, d.cursor, outboxBatchSize)
+		if err != nil || len(evs) == 0 {
+			return err
+		}
+		d.mu.RLock()
+		sinks := d.local
+		d.mu.RUnlock()
+		for _, ev := range evs {
+			for _, s := range sinks {
+				if err := s.Publish(ctx, ev); err != nil {
+					slog.Warn("local event sink failed", "event_id", ev.ID, "error", err)
+				}
+			}
+			d.cursor = ev.ID
+		}
+		if len(evs) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// dispatchDurable delivers pending rows to the durable sinks if this instance
+// holds the dispatcher lock.
+func (d *outboxDispatcher) dispatchDurable(ctx context.Context) error {
+	d.mu.RLock()
+	sinks := d.durable
+	d.mu.RUnlock()
+	if len(sinks) == 0 {
+		return nil
+	}
+	if ok, err := d.acquireLeadership(ctx); !ok {
+		return err
+	}
+
+	evs, err := d.load(ctx, `SELECT id, event_type, player_id, tenant_id, game_id, payload, created_at FROM checkpoint_outbox
+		WHERE dispatched_at IS NULL ORDER BY id LIMIT $1`, outboxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]bool)
+	for _, ev := range evs {
+		if blocked[ev.PlayerID] {
+			continue
+		}
+		var sinkErr error
+		for _, s := range sinks {
+			if sinkErr = s.Publish(ctx, ev); sinkErr != nil {
+				break
+			}
+		}
+		if sinkErr != nil {
+			slog.Warn("durable event sink failed, will retry", "event_id", ev.ID, "player_id", ev.PlayerID, "error", sinkErr)
+			blocked[ev.PlayerID] = true
+			continue
+		}
+		if _, err := d.db.ExecContext(ctx, `UPDATE checkpoint_outbox SET dispatched_at = now() WHERE id = $1`, ev.ID); err != nil {
+			return err
+		}
+	}
+
+	if time.Since(d.lastPrune) < time.Hour {
+		return nil
+	}
+	d.lastPrune = time.Now()
+	_, err = d.db.ExecContext(ctx, `DELETE FROM checkpoint_outbox WHERE dispatched_at < $1`, time.Now().Add(-outboxRetention))
+	return err
+}
+
+// acquireLeadership holds the dispatcher advisory lock on a dedicated
+// connection, re-checking the connection each pass so a dropped session (and
+// with it the lock) is noticed.
+func (d *outboxDispatcher) acquireLeadership(ctx context.Context) (bool, error) {
+	if d.leader != nil {
+		if err := d.leader.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		d.leader.Close()
+		d.leader = nil
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, outboxLockKey).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return false, err
+	}
+	slog.Info("became outbox dispatcher")
+	d.leader = conn
+	return true, nil
+}
+
+// releaseLeadership drops the advisory lock before the connection goes back
+// to the pool, so another instance can take over straight away.
+func (d *outboxDispatcher) releaseLeadership() {
+	if d.leader == nil {
+		return
+	}
+	d.leader.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, outboxLockKey)
+	d.leader.Close()
+	d.leader = nil
+}
+
+func (d *outboxDispatcher) load(ctx context.Context, query string, args ...any) ([]model.CheckpointEvent, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var evs []model.CheckpointEvent
+	for rows.Next() {
+		var ev model.CheckpointEvent
+		var payload []byte
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.PlayerID, &ev.TenantID, &ev.GameID, &payload, &ev.OccurredAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &ev.Checkpoint); err != nil {
+			return nil, fmt.Errorf("decoding outbox row %d: %w", ev.ID, err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs, rows.Err()
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"studentbackendgosql/model"
+)
+
+// Game is a namespace of checkpoints. It lives in model so the Go client
+// shares it.
+type Game = model.Game
+
+// gameRefreshInterval is how often each instance reloads the games, so one
+// registered on another instance is served here too.
+const gameRefreshInterval = time.Minute
+
+// gameIDPattern is the form of a game ID, which appears in URLs.
+var gameIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+const contextKeyGame contextKey = "game"
+
+// gameConfig is a registered game with its checkpoint schema decoded.
+type gameConfig struct {
+	Game
+	schema map[string]any
+}
+
+// gameRegistry caches the games table in memory; checkpoint routes consult
+// it on every request.
+type gameRegistry struct {
+	mu    sync.RWMutex
+	games map[string]*gameConfig
+}
+
+var games = &gameRegistry{games: make(map[string]*gameConfig)}
+
+// load replaces the cached games with those in conn.
+func (g *gameRegistry) load(ctx context.Context, conn *sql.DB) error {
+	rows, err := conn.QueryContext(ctx, `SELECT `+gameColumns+` FROM games`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	loaded := make(map[string]*gameConfig)
+	for rows.Next() {
+		game, err := scanGame(rows)
+		if err != nil {
+			return err
+		}
+		cfg, err := newGameConfig(game)
+		if err != nil {
+			return fmt.Errorf("game %q: %w", game.ID, err)
+		}
+		loaded[game.ID] = cfg
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.games = loaded
+	g.mu.Unlock()
+	return nil
+}
+
+// run reloads the games every gameRefreshInterval until ctx is cancelled.
+func (g *gameRegistry) run(ctx context.Context) {
+	ticker := time.NewTicker(gameRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.load(ctx, db); err != nil {
+				slog.Error("reloading games", "error", err)
+			}
+		}
+	}
+}
+
+func (g *gameRegistry) get(id string) (*gameConfig, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	cfg, ok := g.games[id]
+	return cfg, ok
+}
+
+// allowsGameOrigin reports whether path is under /api/games/{game} for a game
+// listing origin among its own.
+func (g *gameRegistry) allowsGameOrigin(path, origin string) bool {
+	rest, ok := strings.CutPrefix(path, "/api/games/")
+	if !ok {
+		return false
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	cfg, ok := g.get(id)
+	return ok && slices.Contains(cfg.AllowedOrigins, origin)
+}
+
+// gameCORS wraps next in the CORS policy built from options. Every route
+// accepts the server-wide origins; the routes of a game also accept that game's
+// own origins, which gameMiddleware checks again once the game is resolved.
+func gameCORS(options []handlers.CORSOption, next http.Handler) http.Handler {
+	serverWide := handlers.CORS(append(slices.Clone(options), handlers.AllowedOriginValidator(allowsServerOrigin))...)(next)
+	// Only used once allowsGameOrigin has accepted the origin.
+	forGame := handlers.CORS(append(slices.Clone(options), handlers.AllowedOriginValidator(func(string) bool { return true }))...)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowsServerOrigin(origin) && games.allowsGameOrigin(r.URL.Path, origin) {
+			forGame.ServeHTTP(w, r)
+			return
+		}
+		serverWide.ServeHTTP(w, r)
+	})
+}
+
+func newGameConfig(game Game) (*gameConfig, error) {
+	cfg := &gameConfig{Game: game}
+	if len(game.CheckpointSchema) > 0 {
+		if err := json.Unmarshal(game.CheckpointSchema, &cfg.schema); err != nil || cfg.schema == nil {
+			return nil, errors.New("checkpoint_schema must be a JSON Schema object")
+		}
+	}
+	return cfg, nil
+}
+
+// gameMiddleware resolves the {game} of /api/games/{game} routes. Browser
+// requests to a game are only accepted from the server-wide origins and the
+// game's own.
+func gameMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := games.get(mux.Vars(r)["game"])
+		if !ok {
+			writeError(w, r, errGameNotFound())
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !allowsServerOrigin(origin) && !slices.Contains(cfg.AllowedOrigins, origin) {
+			writeError(w, r, errForbidden(codeForbidden, "This origin may not use game "+cfg.ID))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyGame, cfg)))
+	})
+}
+
+// gameFromContext is the game whose checkpoints the request works on: the
+// route's, or the default game for the original routes.
+func gameFromContext(ctx context.Context) *gameConfig {
+	if cfg, ok := ctx.Value(contextKeyGame).(*gameConfig); ok {
+		return cfg
+	}
+	if cfg, ok := games.get(model.DefaultGameID); ok {
+		return cfg
+	}
+	return &gameConfig{Game: Game{ID: model.DefaultGameID}}
+}
+
+// withGame returns ctx working on game id, for maintenance commands, admin
+// routes that name the game in a parameter and events about existing rows.
+// Callers taking id from input check it with games.get first.
+func withGame(ctx context.Context, id string) context.Context {
+	cfg, ok := games.get(id)
+	if !ok {
+		cfg = &gameConfig{Game: Game{ID: id}}
+	}
+	return context.WithValue(ctx, contextKeyGame, cfg)
+}
+
+// withGameParam returns r's context working on the game named by ?game=, or
+// on the default game when there is none.
+func withGameParam(r *http.Request) (context.Context, *apiError) {
+	id := r.URL.Query().Get("game")
+	if id == "" {
+		return r.Context(), nil
+	}
+	if _, ok := games.get(id); !ok {
+		return nil, errGameNotFound()
+	}
+	return withGame(r.Context(), id), nil
+}
+
+// gameIDFromContext is the game every checkpoint query of the request is
+// confined to.
+func gameIDFromContext(ctx context.Context) string {
+	return gameFromContext(ctx).ID
+}
+
+// checkCheckpointForGame enforces the game's rules on cp before it is written
+// in tx: the size limit, the checkpoint schema and, for new checkpoints, the
+// per-player quota.
+func checkCheckpointForGame(ctx context.Context, tx *sql.Tx, cp Checkpoint, creating bool) *apiError {
+	cfg := gameFromContext(ctx)
+	if cfg.MaxCheckpointBytes > 0 && len(cp.CheckpointData) > cfg.MaxCheckpointBytes {
+		return errValidation(fmt.Sprintf("checkpoint_data may be at most %d bytes in %s", cfg.MaxCheckpointBytes, cfg.ID))
+	}
+	if cfg.schema != nil {
+		var data any
+		if err := json.Unmarshal([]byte(cp.CheckpointData), &data); err != nil {
+			return errValidation("checkpoint_data must be JSON in " + cfg.ID)
+		}
+		if err := validateSchema(cfg.schema, data); err != nil {
+			return errValidation("checkpoint_data does not match the schema of " + cfg.ID + ": " + err.Error())
+		}
+	}
+	if creating && cfg.MaxCheckpointsPerPlayer > 0 && cp.PlayerID != "" {
+		// Creates for the same player wait here until the first commits, so
+		// they count each other's checkpoints.
+		lock := `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2 || '/' || $3))`
+		if _, err := tx.ExecContext(ctx, lock, cfg.ID, tenantFromContext(ctx), cp.PlayerID); err != nil {
+			return errInternal("locking player quota", err)
+		}
+		var n int
+		query := `SELECT count(*) FROM gameplay_checkpoints WHERE game_id = $1 AND tenant_id = $2 AND player_id = $3`
+		if err := tx.QueryRowContext(ctx, query, cfg.ID, tenantFromContext(ctx), cp.PlayerID).Scan(&n); err != nil {
+			return errInternal("counting checkpoints", err)
+		}
+		if n >= cfg.MaxCheckpointsPerPlayer {
+			return errForbidden(codeQuotaExceeded, fmt.Sprintf("A player may keep at most %d checkpoints in %s", cfg.MaxCheckpointsPerPlayer, cfg.ID))
+		}
+	}
+	return nil
+}
+
+// gameColumns are the columns scanned by scanGame, in order.
+const gameColumns = `id, name, checkpoint_schema, COALESCE(max_checkpoints_per_player, 0), COALESCE(max_checkpoint_bytes, 0), allowed_origins, created_at`
+
+func scanGame(row rowScanner) (Game, error) {
+	var g Game
+	var schema []byte
+	err := row.Scan(&g.ID, &g.Name, &schema, &g.MaxCheckpointsPerPlayer, &g.MaxCheckpointBytes, pq.Array(&g.AllowedOrigins), &g.CreatedAt)
+	g.CheckpointSchema = schema
+	if g.AllowedOrigins == nil {
+		g.AllowedOrigins = []string{}
+	}
+	return g, err
+}
+
+// validateGame checks a game registration.
+func validateGame(g Game) *apiError {
+	if !gameIDPattern.MatchString(g.ID) {
+		return errValidation("id must be 1-63 lowercase letters, digits or hyphens")
+	}
+	if g.Name == "" {
+		return errValidation("name is required")
+	}
+	if g.MaxCheckpointsPerPlayer < 0 || g.MaxCheckpointBytes < 0 {
+		return errValidation("Limits must not be negative")
+	}
+	for i, o := range g.AllowedOrigins {
+		if !isOrigin(o) {
+			return &apiError{Status: http.StatusUnprocessableEntity, Code: codeValidationFailed,
+				Detail: fmt.Sprintf("allowed_origins[%d]: %q is not an origin like https://example.com", i, o)}
+		}
+	}
+	cfg, err := newGameConfig(g)
+	if err != nil {
+		return errValidation(err.Error())
+	}
+	if err := checkSchemaKeywords(cfg.schema); err != nil {
+		return &apiError{Status: http.StatusUnprocessableEntity, Code: codeUnsupportedSchema, Detail: "checkpoint_schema " + err.Error()}
+	}
+	return nil
+}
+
+// nullLimit stores an unlimited (zero) limit as NULL.
+func nullLimit(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n > 0}
+}
+
+// listGames handles GET /api/admin/games.
+func listGames(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), `SELECT `+gameColumns+` FROM games ORDER BY id`)
+	if err != nil {
+		writeError(w, r, errInternal("retrieving games", err))
+		return
+	}
+	defer rows.Close()
+
+	list := []Game{}
+	for rows.Next() {
+		g, err := scanGame(rows)
+		if err != nil {
+			writeError(w, r, errInternal("scanning game row", err))
+			return
+		}
+		list = append(list, g)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over game rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+// createGame handles POST /api/admin/games, registering a game.
+func createGame(w http.ResponseWriter, r *http.Request) {
+	var g Game
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	if err := validateGame(g); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := `INSERT INTO games (id, name, checkpoint_schema, max_checkpoints_per_player, max_checkpoint_bytes, allowed_origins)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING RETURNING ` + gameColumns
+	created, err := scanGame(db.QueryRowContext(r.Context(), query, g.ID, g.Name, nullSchema(g.CheckpointSchema),
+		nullLimit(g.MaxCheckpointsPerPlayer), nullLimit(g.MaxCheckpointBytes), pq.Array(nonNil(g.AllowedOrigins))))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeGameExists, Detail: "A game with this id already exists"})
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("creating game", err))
+		return
+	}
+	reloadGames(r.Context())
+
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+// getGame handles GET /api/admin/games/{game}.
+func getGame(w http.ResponseWriter, r *http.Request) {
+	g, err := scanGame(db.QueryRowContext(r.Context(), `SELECT `+gameColumns+` FROM games WHERE id = $1`, mux.Vars(r)["game"]))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errGameNotFound())
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("retrieving game", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, g)
+}
+
+// updateGame handles PUT /api/admin/games/{game}, replacing the game's name
+// and rules. Existing checkpoints are not revalidated.
+func updateGame(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["game"]
+	var g Game
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	if g.ID != "" && g.ID != id {
+		writeError(w, r, errValidation("ID in URL and request body do not match"))
+		return
+	}
+	g.ID = id
+	if err := validateGame(g); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := `UPDATE games SET name = $2, checkpoint_schema = $3, max_checkpoints_per_player = $4, max_checkpoint_bytes = $5, allowed_origins = $6
+		WHERE id = $1 RETURNING ` + gameColumns
+	updated, err := scanGame(db.QueryRowContext(r.Context(), query, g.ID, g.Name, nullSchema(g.CheckpointSchema),
+		nullLimit(g.MaxCheckpointsPerPlayer), nullLimit(g.MaxCheckpointBytes), pq.Array(nonNil(g.AllowedOrigins))))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errGameNotFound())
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("updating game", err))
+		return
+	}
+	reloadGames(r.Context())
+
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+// deleteGame handles DELETE /api/admin/games/{game}. The default game and
+// games that still have checkpoints (in any tenant) cannot be deleted.
+func deleteGame(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["game"]
+	if id == model.DefaultGameID {
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeGameInUse, Detail: "The default game cannot be deleted"})
+		return
+	}
+	query := `DELETE FROM games WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM gameplay_checkpoints WHERE game_id = $1) RETURNING id`
+	var deleted string
+	err := db.QueryRowContext(r.Context(), query, id).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, ok := games.get(id); !ok {
+			writeError(w, r, errGameNotFound())
+			return
+		}
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeGameInUse, Detail: "The game still has checkpoints"})
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("deleting game", err))
+		return
+	}
+	reloadGames(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadGames refreshes this instance's registry after a change; other
+// instances catch up within gameRefreshInterval.
+func reloadGames(ctx context.Context) {
+	if err := games.load(context.WithoutCancel(ctx), db); err != nil {
+		slog.ErrorContext(ctx, "reloading games", "error", err)
+	}
+}
+
+func nullSchema(schema json.RawMessage) []byte {
+	if len(schema) == 0 || string(schema) == "null" {
+		return nil
+	}
+	return schema
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
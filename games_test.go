@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/handlers"
+)
+
+func TestGameOriginsOnlyApplyToTheirGame(t *testing.T) {
+	prev := games.games
+	games.games = map[string]*gameConfig{
+		"g1": {Game: Game{ID: "g1", AllowedOrigins: []string{"https://g1.example"}}},
+		"g2": {Game: Game{ID: "g2"}},
+	}
+	t.Cleanup(func() { games.games = prev })
+
+	h := gameCORS([]handlers.CORSOption{handlers.AllowedMethods([]string{"GET", "POST"})}, newRouter())
+	for path, allowed := range map[string]bool{
+		"/api/games/g1/checkpoints": true,
+		"/api/games/g2/checkpoints": false,
+		"/api/gamecheckpoints":      false,
+		"/api/admin/games":          false,
+	} {
+		r := httptest.NewRequest(http.MethodOptions, path, nil)
+		r.Header.Set("Origin", "https://g1.example")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin") == "https://g1.example"; got != allowed {
+			t.Errorf("preflight to %s allowed = %v, want %v", path, got, allowed)
+		}
+	}
+}
+
+func TestValidateGameRejectsMalformedOrigins(t *testing.T) {
+	for _, o := range []string{"*", "https://x.com/", "http://", "https://x.com/play", "x.com", "https://x.com?a=1"} {
+		err := validateGame(Game{ID: "g2", Name: "G2", AllowedOrigins: []string{o}})
+		if err == nil || err.Status != http.StatusUnprocessableEntity {
+			t.Errorf("allowed_origins %q: error %v, want 422", o, err)
+		}
+	}
+	if err := validateGame(Game{ID: "g2", Name: "G2", AllowedOrigins: []string{"https://x.com", "http://localhost:5173"}}); err != nil {
+		t.Errorf("valid origins refused: %v", err)
+	}
+}
+
+// Creating a checkpoint in a game with a quota takes the player's quota lock
+// before counting, so concurrent creates can't both pass the count.
+func TestQuotaCountIsLocked(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2 || '/' || $3))`)).
+		WithArgs("g1", "t1", "t1:p1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM gameplay_checkpoints`)).
+		WithArgs("g1", "t1", "t1:p1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	ctx := context.WithValue(playerContext("t1", "u1", "t1:p1"), contextKeyGame,
+		&gameConfig{Game: Game{ID: "g1", MaxCheckpointsPerPlayer: 2}})
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	apiErr := checkCheckpointForGame(ctx, tx, Checkpoint{PlayerID: "t1:p1"}, true)
+	if apiErr == nil || apiErr.Code != codeQuotaExceeded {
+		t.Errorf("error %v, want %s", apiErr, codeQuotaExceeded)
+	}
+}
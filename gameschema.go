@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateSchema checks v, decoded from JSON, against a JSON Schema. Only the
+// keywords games need are supported: type, enum, properties, required,
+// additionalProperties (as a boolean), items (as a single schema), minimum,
+// maximum, minLength, maxLength, minItems and maxItems; checkSchemaKeywords
+// keeps games from registering others. The returned error names the
+// offending location as a JSON pointer.
+func validateSchema(schema map[string]any, v any) error {
+	return validateAt(schema, v, "")
+}
+
+func validateAt(schema map[string]any, v any, at string) error {
+	where := at
+	if where == "" {
+		where = "/"
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		return fmt.Errorf("%s: must be of type %s", where, typeString(t))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, v) }) {
+		return fmt.Errorf("%s: must be one of the enumerated values", where)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if s, ok := name.(string); ok {
+					if _, present := v[s]; !present {
+						return fmt.Errorf("%s: missing required property %q", where, s)
+					}
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range v {
+			sub, ok := props[name].(map[string]any)
+			if !ok {
+				if extra, ok := schema["additionalProperties"].(bool); ok && !extra {
+					return fmt.Errorf("%s: unexpected property %q", where, name)
+				}
+				continue
+			}
+			if err := validateAt(sub, value, at+"/"+escapePointer(name)); err != nil {
+				return err
+			}
+		}
+
+	case []any:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			return fmt.Errorf("%s: must have at least %v items", where, n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			return fmt.Errorf("%s: must have at most %v items", where, n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateAt(items, item, fmt.Sprintf("%s/%d", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			return fmt.Errorf("%s: must be at least %v characters", where, n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			return fmt.Errorf("%s: must be at most %v characters", where, n)
+		}
+
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			return fmt.Errorf("%s: must be at least %v", where, n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			return fmt.Errorf("%s: must be at most %v", where, n)
+		}
+	}
+	return nil
+}
+
+// schemaKeywords are the keywords validateSchema enforces, plus annotations,
+// which constrain nothing.
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "properties": true, "required": true, "additionalProperties": true, "items": true,
+	"minimum": true, "maximum": true, "minLength": true, "maxLength": true, "minItems": true, "maxItems": true,
+	"$schema": true, "$comment": true, "title": true, "description": true, "default": true, "examples": true,
+}
+
+// checkSchemaKeywords reports the first keyword in schema that validateSchema
+// would not enforce, so a game can't rely on a constraint that lets anything
+// through. The error names the keyword's location as a JSON pointer.
+func checkSchemaKeywords(schema map[string]any) error {
+	return checkKeywordsAt(schema, "")
+}
+
+func checkKeywordsAt(schema map[string]any, at string) error {
+	where := at
+	if where == "" {
+		where = "/"
+	}
+	for _, k := range slices.Sorted(maps.Keys(schema)) {
+		if !schemaKeywords[k] {
+			return fmt.Errorf("%s: keyword %q is not supported", where, k)
+		}
+	}
+	if extra, ok := schema["additionalProperties"]; ok {
+		if _, isBool := extra.(bool); !isBool {
+			return fmt.Errorf("%s: keyword \"additionalProperties\" is only supported as a boolean", where)
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		sub, isSchema := items.(map[string]any)
+		if !isSchema {
+			return fmt.Errorf("%s: keyword \"items\" is only supported as a single schema", where)
+		}
+		if err := checkKeywordsAt(sub, at+"/items"); err != nil {
+			return err
+		}
+	}
+	if props, ok := schema["properties"]; ok {
+		props, isObject := props.(map[string]any)
+		if !isObject {
+			return fmt.Errorf("%s: keyword \"properties\" must be an object", where)
+		}
+		for _, name := range slices.Sorted(maps.Keys(props)) {
+			sub, isSchema := props[name].(map[string]any)
+			subAt := at + "/properties/" + escapePointer(name)
+			if !isSchema {
+				return fmt.Errorf("%s: must be a schema object", subAt)
+			}
+			if err := checkKeywordsAt(sub, subAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesType reports whether v has the schema type t, a type name or a list
+// of them.
+func matchesType(t any, v any) bool {
+	switch t := t.(type) {
+	case string:
+		return hasType(t, v)
+	case []any:
+		return slices.ContainsFunc(t, func(name any) bool {
+			s, _ := name.(string)
+			return hasType(s, v)
+		})
+	}
+	return true
+}
+
+func hasType(name string, v any) bool {
+	switch name {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func typeString(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func schemaNumber(schema map[string]any, keyword string) (float64, bool) {
+	n, ok := schema[keyword].(float64)
+	return n, ok
+}
+
+func jsonEqual(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchemaKeywords(t *testing.T) {
+	for _, tc := range []struct {
+		schema string
+		err    string
+	}{
+		{`{"type":"object","title":"Save","properties":{"level":{"type":"integer","minimum":1}},"required":["level"],"additionalProperties":false}`, ""},
+		{`{"type":"array","items":{"type":"string","maxLength":8}}`, ""},
+		{`{"type":"string","pattern":"^a"}`, `/: keyword "pattern" is not supported`},
+		{`{"oneOf":[{"type":"string"}]}`, `/: keyword "oneOf" is not supported`},
+		{`{"properties":{"a/b":{"$ref":"#/x"}}}`, `/properties/a~1b: keyword "$ref" is not supported`},
+		{`{"items":{"type":"string","format":"email"}}`, `/items: keyword "format" is not supported`},
+		{`{"properties":{"n":{"exclusiveMinimum":0}}}`, `/properties/n: keyword "exclusiveMinimum" is not supported`},
+		{`{"additionalProperties":{"type":"string"}}`, `/: keyword "additionalProperties" is only supported as a boolean`},
+		{`{"items":[{"type":"string"}]}`, `/: keyword "items" is only supported as a single schema`},
+	} {
+		var schema map[string]any
+		if err := json.Unmarshal([]byte(tc.schema), &schema); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if err := checkSchemaKeywords(schema); err != nil {
+			got = err.Error()
+		}
+		if got != tc.err {
+			t.Errorf("%s: error %q, want %q", tc.schema, got, tc.err)
+		}
+	}
+}
+
+func TestCreateGameRejectsUnsupportedSchema(t *testing.T) {
+	fakeProjectSession(t, "tok-project-admin", "user-p", adminRole)
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "", "user-p")
+	expectAdminAudit(mock, "POST /api/admin/games", "")
+	body := `{"id":"g2","name":"G2","checkpoint_schema":{"type":"string","pattern":"^a"}}`
+	w := serve(http.MethodPost, "/api/admin/games", "tok-project-admin", strings.NewReader(body))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), codeUnsupportedSchema) || !strings.Contains(w.Body.String(), "pattern") {
+		t.Fatalf("status %d, body %s; want 422 unsupported_schema naming pattern", w.Code, w.Body)
+	}
+}
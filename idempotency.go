@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// idempotencyKeyHeader carries the client-chosen key for a POST or PATCH.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed from an earlier request.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds stored keys.
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize bounds request bodies read for hashing.
+	maxIdempotentBodySize = 1 << 20
+)
+
+// idempotencyScope is the namespace a request's Idempotency-Key lives in: the
+// player in their tenant, as used by one principal, so an admin impersonating
+// the player or an API key acting as them never shares the player's own keys.
+type idempotencyScope struct {
+	tenantID, playerID, actor string
+}
+
+// idempotencyScopeOf returns the scope of the request in ctx. actor is the
+// impersonating admin if there is one, else the user or API key.
+func idempotencyScopeOf(ctx context.Context) idempotencyScope {
+	actor := impersonatorFromContext(ctx)
+	if actor == "" {
+		actor, _ = ctx.Value(contextKeyUserID).(string)
+	}
+	playerID, _ := ctx.Value(contextKeyPlayerID).(string)
+	return idempotencyScope{tenantID: tenantFromContext(ctx), playerID: playerID, actor: actor}
+}
+
+// idempotencyMiddleware makes POST and PATCH requests carrying an
+// Idempotency-Key safe to retry. The first response per scope and key is
+// stored for config.Idempotency.KeyTTL and replayed for retries with the same
+// body; reusing the key with a different request, or while the first is still
+// running, is a 409. Server errors and panics are not stored, so the client may retry them.
+// It must run after sessionValidationMiddleware and impersonationMiddleware.
+func idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, r, errValidation("Idempotency-Key must be at most 255 characters"))
+			return
+		}
+		// Requests made with an API key that acts as no player are scoped to
+		// the key alone.
+		scope := idempotencyScopeOf(r.Context())
+		if scope.actor == "" {
+			writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+		if err != nil {
+			writeError(w, r, errValidation("Request body could not be read"))
+			return
+		}
+		if len(body) > maxIdempotentBodySize {
+			writeError(w, r, errValidation("Request body is too large to use with an Idempotency-Key"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+
+		claimed, err := claimIdempotencyKey(r.Context(), scope, key, hash)
+		if err != nil {
+			writeError(w, r, errInternal("claiming idempotency key", err))
+			return
+		}
+		if !claimed {
+			replayIdempotentResponse(w, r, scope, key, hash)
+			return
+		}
+
+		// A background context: the response must be stored (or the claim
+		// released) even if the client has gone away.
+		ctx := context.WithoutCancel(r.Context())
+		defer func() {
+			// A panicking handler stored nothing; without the release every
+			// retry would get a 409 until the key expires.
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(ctx, scope, key)
+				panic(p)
+			}
+		}()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusInternalServerError {
+			releaseIdempotencyKey(ctx, scope, key)
+			return
+		}
+		query := `UPDATE idempotency_keys SET status_code = $5, content_type = $6, response_body = $7
+			WHERE tenant_id = $1 AND player_id = $2 AND actor = $3 AND key = $4`
+		if _, err := db.ExecContext(ctx, query, scope.tenantID, scope.playerID, scope.actor, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+			slog.ErrorContext(ctx, "storing idempotent response", "error", err)
+		}
+	})
+}
+
+// releaseIdempotencyKey deletes a claim whose request produced no response
+// worth replaying.
+func releaseIdempotencyKey(ctx context.Context, scope idempotencyScope, key string) {
+	query := `DELETE FROM idempotency_keys WHERE tenant_id = $1 AND player_id = $2 AND actor = $3 AND key = $4`
+	if _, err := db.ExecContext(ctx, query, scope.tenantID, scope.playerID, scope.actor, key); err != nil {
+		slog.ErrorContext(ctx, "releasing idempotency key", "error", err)
+	}
+}
+
+// claimIdempotencyKey records key as in progress for this request, reporting
+// false if a live entry already exists. Expired entries are replaced.
+func claimIdempotencyKey(ctx context.Context, scope idempotencyScope, key, hash string) (bool, error) {
+	query := `INSERT INTO idempotency_keys (tenant_id, player_id, actor, key, request_hash, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, player_id, actor, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL,
+				response_body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= now()
+		RETURNING true`
+	var claimed bool
+	err := db.QueryRowContext(ctx, query, scope.tenantID, scope.playerID, scope.actor, key, hash, time.Now().Add(time.Duration(config.Idempotency.KeyTTL))).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return claimed, err
+}
+
+// replayIdempotentResponse answers a request whose key is already in use.
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, scope idempotencyScope, key, hash string) {
+	var (
+		storedHash  string
+		status      sql.NullInt64
+		contentType sql.NullString
+		body        []byte
+	)
+	query := `SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys
+		WHERE tenant_id = $1 AND player_id = $2 AND actor = $3 AND key = $4`
+	err := db.QueryRowContext(r.Context(), query, scope.tenantID, scope.playerID, scope.actor, key).Scan(&storedHash, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		// The first request failed and released the key in the meantime.
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeIdempotencyInProgress, Detail: "A request with this Idempotency-Key is being processed; retry"})
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("reading idempotency key", err))
+		return
+	}
+
+	switch {
+	case storedHash != hash:
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeIdempotencyKeyReused, Detail: "This Idempotency-Key was already used for a different request"})
+	case !status.Valid:
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codeIdempotencyInProgress, Detail: "A request with this Idempotency-Key is being processed; retry"})
+	default:
+		if contentType.String != "" {
+			w.Header().Set("Content-Type", contentType.String)
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(int(status.Int64))
+		w.Write(body)
+	}
+}
+
+// requestHash identifies a request by method, path and body, so a key reused
+// for anything else is detected.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pruneIdempotencyKeys deletes expired keys every hour until ctx is cancelled.
+func pruneIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`); err != nil {
+				slog.Error("pruning idempotency keys", "error", err)
+			}
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIdempotencyScopeSeparatesImpersonators(t *testing.T) {
+	ctx := playerContext("tenant-a", "user-b", "tenant-a:user-b")
+	own := idempotencyScopeOf(ctx)
+	if own != (idempotencyScope{tenantID: "tenant-a", playerID: "tenant-a:user-b", actor: "user-b"}) {
+		t.Errorf("player's scope %+v", own)
+	}
+	impersonated := idempotencyScopeOf(context.WithValue(ctx, contextKeyImpersonator, "user-a"))
+	if impersonated.actor != "user-a" || impersonated == own {
+		t.Errorf("impersonator's scope %+v, player's %+v", impersonated, own)
+	}
+}
+
+func TestIdempotencyKeyReleasedWhenHandlerPanics(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO idempotency_keys`)).
+		WithArgs("tenant-a", "tenant-a:user-b", "user-b", "key-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE tenant_id = $1 AND player_id = $2 AND actor = $3 AND key = $4`)).
+		WithArgs("tenant-a", "tenant-a:user-b", "user-b", "key-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := idempotencyMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("handler bug")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/api/checkpoints", strings.NewReader(`{}`)).
+		WithContext(playerContext("tenant-a", "user-b", "tenant-a:user-b"))
+	r.Header.Set(idempotencyKeyHeader, "key-1")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("panic was swallowed")
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+}
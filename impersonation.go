@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// impersonatePlayerHeader names the player an admin acts as.
+	impersonatePlayerHeader = "X-Impersonate-Player"
+	// impersonatedPlayerHeader marks every response to an impersonated request.
+	impersonatedPlayerHeader = "X-Impersonated-Player"
+)
+
+// auditImpersonation prefixes the audit action of impersonated requests,
+// which is followed by the method and route template.
+const auditImpersonation = "impersonate"
+
+const contextKeyImpersonator contextKey = "impersonator"
+
+// impersonationMiddleware lets holders of players:impersonate act as the player
+// named by X-Impersonate-Player, for support investigations. The request then
+// runs exactly as that player's own would: as their account, with no
+// permissions, so the player code paths and scoping apply. Every impersonated
+// request is audited under the admin's ID before it runs, or refused if it
+// can't be, and its response carries X-Impersonated-Player. Erasure can't be requested while impersonating. It
+// must run after sessionValidationMiddleware.
+func impersonationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		playerID := r.Header.Get(impersonatePlayerHeader)
+		if playerID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if !hasPermission(ctx, permPlayersImpersonate) {
+			writeError(w, r, errForbidden(codeForbidden, "Impersonation requires the "+permPlayersImpersonate+" permission"))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/me/erasure") {
+			writeError(w, r, errForbidden(codeForbidden, "Erasure can't be requested while impersonating"))
+			return
+		}
+		player, err := findPlayer(ctx, playerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, errPlayerNotFound())
+			return
+		} else if err != nil {
+			writeError(w, r, errInternal("looking up impersonated player", err))
+			return
+		}
+
+		adminID, _ := ctx.Value(contextKeyUserID).(string)
+		roles := grantsFromContext(ctx).Roles
+		ctx = context.WithValue(ctx, contextKeyImpersonator, adminID)
+		ctx = context.WithValue(ctx, contextKeyUserID, player.UserID)
+		ctx = context.WithValue(ctx, contextKeyPlayerID, player.ID)
+		// The admin's roles stay for the audit log; their permissions don't apply.
+		ctx = context.WithValue(ctx, contextKeyGrants, grants{Roles: roles, Permissions: map[string]bool{}})
+		setLogPlayer(ctx, player.ID, roles)
+		setLogImpersonator(ctx, adminID)
+
+		// The audit entry is written before the request runs, and a request
+		// that can't be audited doesn't run.
+		action := auditImpersonation + " " + r.Method + " " + r.URL.Path
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			action = auditImpersonation + " " + r.Method + " " + tmpl
+		}
+		if err := recordAudit(ctx, db, action, 0, player.ID, nil, nil); err != nil {
+			writeError(w, r, errInternal("recording impersonation", err))
+			return
+		}
+
+		w.Header().Set(impersonatedPlayerHeader, player.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// impersonatorFromContext is the admin impersonating the request's player, if
+// any.
+func impersonatorFromContext(ctx context.Context) string {
+	adminID, _ := ctx.Value(contextKeyImpersonator).(string)
+	return adminID
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectImpersonation expects the tenant-a admin's session to impersonate
+// tenant-a:user-b on GET /api/players/me and the audit insert, failing with
+// auditErr if it is not nil.
+func expectImpersonation(mock sqlmock.Sqlmock, auditErr error) {
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM players WHERE id = $1 AND tenant_id = $2`)).
+		WithArgs("tenant-a:user-b", "tenant-a").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "display_name", "avatar_url", "preferences", "is_default", "created_at", "last_seen"}).
+			AddRow("tenant-a:user-b", "user-b", "B", "", []byte(`{}`), true, time.Now(), nil))
+	audit := mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO admin_audit_log`)).
+		WithArgs("user-a", adminRole, "impersonate GET /api/players/me", nil, "tenant-a:user-b", "", "", sqlmock.AnyArg(), "tenant-a")
+	if auditErr != nil {
+		audit.WillReturnError(auditErr)
+	} else {
+		audit.WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+}
+
+func getMeImpersonating() *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/players/me", nil)
+	r.Header.Set("Authorization", "Bearer tok-admin")
+	r.Header.Set(impersonatePlayerHeader, "tenant-a:user-b")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
+
+func TestImpersonationIsAuditedBeforeItRuns(t *testing.T) {
+	fakeSession(t, "tok-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	expectImpersonation(mock, nil)
+	// Only after the audit entry does the handler run, as the player.
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM players WHERE id = $1 AND tenant_id = $2`)).
+		WithArgs("tenant-a:user-b", "tenant-a").
+		WillReturnRows(sqlmock.NewRows(nil))
+
+	w := getMeImpersonating()
+	if w.Code != http.StatusNotFound || w.Header().Get(impersonatedPlayerHeader) != "tenant-a:user-b" {
+		t.Errorf("status %d, %s %q", w.Code, impersonatedPlayerHeader, w.Header().Get(impersonatedPlayerHeader))
+	}
+}
+
+func TestImpersonationFailsWithoutAudit(t *testing.T) {
+	fakeSession(t, "tok-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	expectImpersonation(mock, errors.New("audit log unavailable"))
+
+	if w := getMeImpersonating(); w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", w.Code)
+	}
+}
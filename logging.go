@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const contextKeyRequestInfo contextKey = "requestInfo"
+
+// requestIDHeader is read from incoming requests and echoed on every response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs so they can't bloat log lines.
+const maxRequestIDLength = 128
+
+// requestInfo carries the per-request fields attached to every log line.
+// It is stored as a pointer so middleware further down the chain (routing,
+// session validation) can fill in fields the outer access log also sees.
+type requestInfo struct {
+	RequestID string
+	Route     string
+	PlayerID  string
+	TenantID  string
+	Roles     []string
+	// ImpersonatedBy is the admin acting as PlayerID, if any.
+	ImpersonatedBy string
+}
+
+// newLogger builds the process logger from LOG_LEVEL (debug, info, warn, error;
+// default info) and LOG_FORMAT (json or text; default json).
+func newLogger(out io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", s, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+		h = slog.NewJSONHandler(out, opts)
+	case "text":
+		h = slog.NewTextHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q (want json or text)", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// contextHandler adds the request ID, route, player ID, roles and trace ID
+// found in the record's context, so callers only have to use the *Context
+// logging functions to get them.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if info, ok := ctx.Value(contextKeyRequestInfo).(*requestInfo); ok {
+		rec.AddAttrs(slog.String("request_id", info.RequestID))
+		if info.Route != "" {
+			rec.AddAttrs(slog.String("route", info.Route))
+		}
+		if info.PlayerID != "" {
+			rec.AddAttrs(slog.String("player_id", info.PlayerID))
+			if info.TenantID != "" {
+				rec.AddAttrs(slog.String("tenant_id", info.TenantID))
+			}
+			if len(info.Roles) > 0 {
+				rec.AddAttrs(slog.Any("roles", info.Roles))
+			}
+			if info.ImpersonatedBy != "" {
+				rec.AddAttrs(slog.String("impersonated_by", info.ImpersonatedBy))
+			}
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rec.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal logs msg at error level and exits, replacing log.Fatal for startup errors.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestIDMiddleware assigns each request an ID (reusing a well-formed
+// X-Request-ID from the client), returns it in the response header and writes
+// one access log line when the request completes.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{RequestID: id}
+		ctx := context.WithValue(r.Context(), contextKeyRequestInfo, info)
+
+		m := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if m.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", m.Code,
+			"duration_ms", m.Duration.Milliseconds(),
+			"bytes", m.Written,
+		)
+	})
+}
+
+// routeLoggingMiddleware records the matched mux route template on the request's
+// log fields. It must be registered with router.Use so the route is known.
+func routeLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(contextKeyRequestInfo).(*requestInfo); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					info.Route = tmpl
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setLogPlayer records the authenticated player on the request's log fields.
+func setLogPlayer(ctx context.Context, playerID string, roles []string) {
+	if info, ok := ctx.Value(contextKeyRequestInfo).(*requestInfo); ok {
+		info.PlayerID = playerID
+		info.TenantID = tenantFromContext(ctx)
+		info.Roles = roles
+	}
+}
+
+// setLogImpersonator records the admin impersonating the request's player.
+func setLogImpersonator(ctx context.Context, adminID string) {
+	if info, ok := ctx.Value(contextKeyRequestInfo).(*requestInfo); ok {
+		info.ImpersonatedBy = adminID
+	}
+}
+
+// requestIDFromContext returns the request ID assigned by requestIDMiddleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKeyRequestInfo).(*requestInfo); ok {
+		return info.RequestID
+	}
+	return ""
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -4,44 +4,43 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"time" // Import time package for the timestamp fields
+	"time"
 
 	// PostgreSQL driver
 	"github.com/descope/go-sdk/descope/client"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/attribute"
 
 	// Import the handlers package for CORS middleware
 	"github.com/gorilla/handlers"
+
+	"studentbackendgosql/model"
 )
 
-// Checkpoint represents a user record in the database.
-// CHQ: Gemini AI added CreatedAt and LastEditedAt to the struct
-type Checkpoint struct {
-	ID             int       `json:"id"`
-	Username       string    `json:"user_name"`
-	CheckpointData string    `json:"checkpoint_data"`
-	CreatedAt      time.Time `json:"created_at"`
-	LastEditedAt   time.Time `json:"last_edited_at"`
-	playerID	   string    `json:"player_id"`
-}
+// Checkpoint represents a user record in the database. It lives in model so
+// the Go client shares it.
+type Checkpoint = model.Checkpoint
 
 var db *sql.DB
 var descopeClient *client.DescopeClient
 
-var isAnAdmin bool
 // Define a custom key type to avoid collisions
 type contextKey string
 
 const contextKeyUserID contextKey = "userID"
 const contextKeyPlayerID contextKey = "playerID" // A key for the player ID
-
+const contextKeySessionExpires contextKey = "sessionExpires" // When the session token expires, if it does
 
 var listOfDBConnections = []string{"GOOGLE_CLOUD_SQL_BSS", "AVIEN_MYSQL_DB_CONNECTION", "AVIEN_PSQL_DB_CONNECTION", "GOOGLE_VM_HOSTED_SQL"}
 
@@ -63,81 +62,223 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	logger, err := newLogger(os.Stderr)
+	if err != nil {
+		log.Fatalf("Error configuring logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	config, err = loadConfig()
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	setCORSOrigins(config)
+	sessions = newSessionCache(config.SessionCache)
+	recentEvents = newEventLog(config.Events.BufferSize)
+
+
+	// Maintenance subcommands (export, import) run instead of the server.
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Initialize database connection
-	var err error
-	dbConnStr := os.Getenv(listOfDBConnections[3])
+	dbConnStr := os.Getenv(config.Database)
 	if dbConnStr == "" {
-		log.Fatal("DATABASE_URL environment variable not set.")
+		fatal("database connection environment variable not set", "env", config.Database)
+	}
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		fatal("error initializing tracing", "error", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	db, err = sql.Open("postgres", dbConnStr)
+	db, err = openTracedDB(dbConnStr)
 	if err != nil {
-		log.Fatalf("Error opening database: %v", err)
+		fatal("error opening database", "error", err)
 	}
 	defer db.Close()
 
 	err = db.Ping()
 	if err != nil {
-		log.Fatalf("Error connecting to the database: %v", err)
+		fatal("error connecting to the database", "error", err)
 	}
-	fmt.Println("Successfully connected to the database!")
+	slog.Info("successfully connected to the database")
 
-	projectID := os.Getenv("DESCOPE_PROJECT_BSS_ID")
-	if projectID == "" {
-		log.Fatal("DESCOPE_PROJECT_ID environment variable not set.")
+	rateLimitBuckets = newRateLimitStore(config.RateLimits.Store, db)
+	if _, shared := rateLimitBuckets.(postgresRateLimitStore); shared {
+		go pruneRateLimitBuckets(context.Background())
 	}
-	descopeClient, err = client.NewWithConfig(&client.Config{ProjectID: projectID})
-	if err != nil {
-		log.Fatalf("failed to initialize Descope client: %v", err)
+
+	if config.Auth.ProjectID == "" {
+		fatal("no Descope project configured; set auth.project_id or DESCOPE_PROJECT_BSS_ID")
 	}
+	descopeClient, err = client.NewWithConfig(&client.Config{ProjectID: config.Auth.ProjectID})
+	if err != nil {
+		fatal("failed to initialize Descope client", "error", err)
+	}
+
+	// Games are served from memory and reloaded periodically, so a game
+	// registered on another instance shows up here too.
+	if err := games.load(context.Background(), db); err != nil {
+		fatal("error loading games", "error", err)
+	}
+	go games.run(context.Background())
+	go sessions.run(context.Background(), dbConnStr)
+
+	// Checkpoint events are written to the outbox with each mutation and
+	// delivered from there: to this process's WebSocket and SSE clients, and
+	// at least once to webhooks.
+	events = newOutboxDispatcher(db, dbConnStr)
+	events.RegisterLocal(hub)
+	events.RegisterLocal(recentEvents)
+
+	webhookDispatcher := newWebhookDispatcher(db, newWebhookClient())
+	events.Register(webhookDispatcher)
+	go webhookDispatcher.run(context.Background())
+	go pruneIdempotencyKeys(context.Background())
+	go func() {
+		if err := events.run(context.Background()); err != nil {
+			slog.Error("outbox dispatcher stopped", "error", err)
+		}
+	}()
 
 	// Initialize the router
-	router := mux.NewRouter()
+	router := newRouter()
 
-	// All routes now go through the mux router, including static files
-	router.HandleFunc("/", helloHandler)
-	router.HandleFunc("/favicon.ico", faviconHandler)
-
-	// Protected routes (require session validation)
-    protectedRoutes := router.PathPrefix("/api").Subrouter()
-    protectedRoutes.Use(sessionValidationMiddleware) // Apply middleware to all routes in this subrouter
-	protectedRoutes.HandleFunc("/gamecheckpoints", createCheckpoint).Methods("POST")
-	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", getCheckpoint).Methods("GET")
-	protectedRoutes.HandleFunc("/gamecheckpoints", getAllCheckpoints).Methods("GET")
- 	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", updateCheckpoint).Methods("PUT")
-	// protectedRoutes.HandleFunc("/gamecheckpoints/{id}", updateCheckpointALT).Methods("PATCH")
-	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", deleteCheckpoint).Methods("DELETE")
-
-	theOrigins := []string{
-		"https://studentfrontendreact-git-test-point-conrad1451s-projects.vercel.app",
-		"https://studentfrontendreact.vercel.app",
-		"http://localhost:5173",
-		"http://localhost:5174",
+	if err := checkSpecCoverage(router, openAPISpec); err != nil {
+		fatal("OpenAPI document is out of date", "error", err)
 	}
 
 	// --- CORS Setup ---
-	// Create a list of allowed origins (e.g., your front-end URL)
-	allowedOrigins := handlers.AllowedOrigins(theOrigins)
+	// Allow the configured origins everywhere and each game's own origins on that game's routes (see gameCORS).
+	// The configured origins are reloaded on SIGHUP.
+	go reloadCORSOnHangup()
 
 	// Create a list of allowed methods (GET, POST, etc.)
-	allowedMethods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	allowedMethods := handlers.AllowedMethods(config.CORS.AllowedMethods)
+
+	// Create a list of allowed headers, including Content-Type, the W3C trace context headers and any configured ones
+	allowedHeaders := handlers.AllowedHeaders(append([]string{"Content-Type", "Authorization", "traceparent", "tracestate", requestIDHeader, idempotencyKeyHeader, playerIDHeader, tenantIDHeader, impersonatePlayerHeader}, config.CORS.AllowedHeaders...))
 
-	// Create a list of allowed headers, including Content-Type
-	allowedHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization"})
+	// Let the client read the request ID (to quote in bug reports), see replayed and impersonated responses and pace itself
+	exposedHeaders := handlers.ExposedHeaders([]string{requestIDHeader, idempotentReplayedHeader, impersonatedPlayerHeader,
+		rateLimitLimitHeader, rateLimitRemainingHeader, rateLimitResetHeader, retryAfterHeader})
 
-	// Wrap your router with the CORS handler
-	corsRouter := handlers.CORS(allowedOrigins, allowedMethods, allowedHeaders)(router)
+	// Wrap your router with the per-IP rate limit, then the CORS handler, so 429s carry CORS headers
+	corsRouter := gameCORS([]handlers.CORSOption{allowedMethods, allowedHeaders, exposedHeaders}, ipRateLimitHandler(router))
 	// --- End of CORS Setup ---
 
 	// Start the HTTP server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port
+	port := config.Port
+
+	// Without a certificate, serve plain HTTP behind a TLS-terminating proxy
+	if !config.TLS.enabled() {
+		slog.Info("server listening", "port", port)
+
+		// Serve the corsRouter behind the request ID and access log middleware
+		err = newServer(":"+port, requestIDMiddleware(corsRouter), nil, config.HTTP).ListenAndServe()
+		fatal("server stopped", "error", err)
 	}
-	fmt.Printf("Server listening on port %s...\n", port)
 
-	// Pass the corsRouter to ListenAndServe
-	log.Fatal(http.ListenAndServe(":"+port, corsRouter))
+	certs, err := newCertReloader(config.TLS.CertFile, config.TLS.KeyFile)
+	if err != nil {
+		fatal("error loading TLS certificate", "error", err)
+	}
+	go certs.run(context.Background())
+	tlsConfig, err := newTLSConfig(config.TLS, certs)
+	if err != nil {
+		fatal("invalid TLS configuration", "error", err)
+	}
+	slog.Info("server listening with TLS", "port", port, "admin_client_certs", config.TLS.ClientCAFile != "")
+
+	// Serve HTTPS and HTTP/2, with HSTS outside the request ID and access log middleware
+	srv := newServer(":"+port, hstsMiddleware(config.TLS.HSTSMaxAge, requestIDMiddleware(corsRouter)), tlsConfig, config.HTTP)
+	err = srv.ListenAndServeTLS("", "")
+	fatal("server stopped", "error", err)
+}
+
+// newRouter registers every route and the router-wide middleware.
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(serviceName))
+	router.Use(routeLoggingMiddleware)
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
+	// All routes now go through the mux router, including static files
+	router.HandleFunc("/", helloHandler)
+	router.HandleFunc("/favicon.ico", faviconHandler)
+	router.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	router.HandleFunc("/docs", docsHandler).Methods("GET")
+	router.HandleFunc("/docs/{asset}", docsAssetHandler).Methods("GET")
+
+	// Protected routes (require session validation)
+    protectedRoutes := router.PathPrefix("/api").Subrouter()
+    protectedRoutes.Use(sessionValidationMiddleware) // Apply middleware to all routes in this subrouter
+	protectedRoutes.Use(clientCertGrantsMiddleware)
+	protectedRoutes.Use(impersonationMiddleware)
+	protectedRoutes.Use(playerRateLimitMiddleware)
+	protectedRoutes.Use(idempotencyMiddleware)
+	protectedRoutes.HandleFunc("/gamecheckpoints", traced("createCheckpoint", createCheckpoint)).Methods("POST")
+	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", traced("getCheckpoint", getCheckpoint)).Methods("GET")
+	protectedRoutes.HandleFunc("/gamecheckpoints", traced("getAllCheckpoints", getAllCheckpoints)).Methods("GET")
+	protectedRoutes.HandleFunc("/gamecheckpoints:batch", traced("batchCheckpoints", batchCheckpoints)).Methods("POST")
+ 	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", traced("updateCheckpoint", updateCheckpoint)).Methods("PUT")
+	// protectedRoutes.HandleFunc("/gamecheckpoints/{id}", updateCheckpointALT).Methods("PATCH")
+	protectedRoutes.HandleFunc("/gamecheckpoints/{id}", traced("deleteCheckpoint", deleteCheckpoint)).Methods("DELETE")
+	protectedRoutes.HandleFunc("/ws", checkpointEventsSocket).Methods("GET")
+	protectedRoutes.HandleFunc("/players", listPlayers).Methods("GET")
+	protectedRoutes.HandleFunc("/players", createPlayer).Methods("POST")
+	protectedRoutes.HandleFunc("/players/me", getMyPlayer).Methods("GET")
+	protectedRoutes.HandleFunc("/players/me", updateMyPlayer).Methods("PATCH")
+	protectedRoutes.HandleFunc("/me/export", exportPlayerData).Methods("GET")
+	protectedRoutes.HandleFunc("/me/erasure", requestErasure).Methods("POST")
+	protectedRoutes.HandleFunc("/me/erasure/confirm", confirmErasure).Methods("POST")
+
+	// Game routes serve the same handlers for one registered game; the
+	// /gamecheckpoints routes above are the default game's.
+	gameRoutes := protectedRoutes.PathPrefix("/games/{game}").Subrouter()
+	gameRoutes.Use(gameMiddleware)
+	gameRoutes.HandleFunc("/checkpoints", traced("createCheckpoint", createCheckpoint)).Methods("POST")
+	gameRoutes.HandleFunc("/checkpoints/{id}", traced("getCheckpoint", getCheckpoint)).Methods("GET")
+	gameRoutes.HandleFunc("/checkpoints", traced("getAllCheckpoints", getAllCheckpoints)).Methods("GET")
+	gameRoutes.HandleFunc("/checkpoints:batch", traced("batchCheckpoints", batchCheckpoints)).Methods("POST")
+	gameRoutes.HandleFunc("/checkpoints/{id}", traced("updateCheckpoint", updateCheckpoint)).Methods("PUT")
+	gameRoutes.HandleFunc("/checkpoints/{id}", traced("deleteCheckpoint", deleteCheckpoint)).Methods("DELETE")
+
+	// Admin routes (each requires a permission granted by the session's roles)
+	adminRoutes := protectedRoutes.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(adminClientCertMiddleware)
+	adminRoutes.Use(auditMiddleware)
+	adminRoutes.HandleFunc("/events", requirePermission(permCheckpointsReadAny, adminEventsStream)).Methods("GET")
+	adminRoutes.HandleFunc("/webhooks", requirePermission(permWebhooksManage, createWebhook)).Methods("POST")
+	adminRoutes.HandleFunc("/webhooks", requirePermission(permWebhooksManage, listWebhooks)).Methods("GET")
+	adminRoutes.HandleFunc("/webhooks/{id}", requirePermission(permWebhooksManage, deleteWebhook)).Methods("DELETE")
+	adminRoutes.HandleFunc("/webhook-deliveries", requirePermission(permWebhooksManage, listWebhookDeliveries)).Methods("GET")
+	adminRoutes.HandleFunc("/webhook-deliveries/{id}/redeliver", requirePermission(permWebhooksManage, redeliverWebhook)).Methods("POST")
+	adminRoutes.HandleFunc("/checkpoints/export", requirePermission(permCheckpointsReadAny, exportCheckpoints)).Methods("GET")
+	adminRoutes.HandleFunc("/checkpoints/import", requirePermission(permCheckpointsWriteAny, importCheckpoints)).Methods("POST")
+	adminRoutes.HandleFunc("/players", requirePermission(permPlayersModerate, listPlayersAsAdmin)).Methods("GET")
+	adminRoutes.HandleFunc("/players", requirePermission(permPlayersModerate, createPlayerAsAdmin)).Methods("POST")
+	adminRoutes.HandleFunc("/players/{playerID}", requirePermission(permPlayersModerate, getPlayerAsAdmin)).Methods("GET")
+	adminRoutes.HandleFunc("/players/{playerID}", requirePermission(permPlayersModerate, updatePlayerAsAdmin)).Methods("PATCH")
+	adminRoutes.HandleFunc("/players/{playerID}", requirePermission(permPlayersModerate, deletePlayerAsAdmin)).Methods("DELETE")
+	adminRoutes.HandleFunc("/players/{playerID}/data", requirePermission(permPlayersModerate, erasePlayerData)).Methods("DELETE")
+	adminRoutes.HandleFunc("/audit", requirePermission(permAuditRead, listAuditLog)).Methods("GET")
+	adminRoutes.HandleFunc("/games", requirePermission(permGamesManage, listGames)).Methods("GET")
+	adminRoutes.HandleFunc("/games", requirePermission(permGamesManage, createGame)).Methods("POST")
+	adminRoutes.HandleFunc("/games/{game}", requirePermission(permGamesManage, getGame)).Methods("GET")
+	adminRoutes.HandleFunc("/games/{game}", requirePermission(permGamesManage, updateGame)).Methods("PUT")
+	adminRoutes.HandleFunc("/games/{game}", requirePermission(permGamesManage, deleteGame)).Methods("DELETE")
+	adminRoutes.HandleFunc("/api-keys", requirePermission(permAPIKeysManage, createAPIKey)).Methods("POST")
+	adminRoutes.HandleFunc("/api-keys", requirePermission(permAPIKeysManage, listAPIKeys)).Methods("GET")
+	adminRoutes.HandleFunc("/api-keys/{id}", requirePermission(permAPIKeysManage, revokeAPIKey)).Methods("DELETE")
+	adminRoutes.HandleFunc("/sessions/cache", requirePermission(permSessionsManage, getSessionCacheStats)).Methods("GET")
+	adminRoutes.HandleFunc("/sessions/{userID}", requirePermission(permSessionsManage, revokeUserSessions)).Methods("DELETE")
+
+	return router
 }
 
 // CHQ: Gemini AI generated function
@@ -147,49 +288,110 @@ func helloHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "This is the server for the student records app. It's written in Go (aka GoLang).")
 }
 
+// writeJSON encodes v as the JSON response body with the given status code,
+// inside its own span so slow encodes of large checkpoint lists show up in traces.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	_, span := tracer.Start(r.Context(), "json.encode")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		recordSpanError(span, err)
+		slog.ErrorContext(r.Context(), "error encoding response", "error", err)
+	}
+}
+
 // CHQ: Gemini AI created function
 // sessionValidationMiddleware is a middleware to validate the Descope session token.
 func sessionValidationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sessionToken := r.Header.Get("Authorization")
+		if sessionToken == "" && (websocket.IsWebSocketUpgrade(r) || acceptsEventStream(r)) {
+			// Browsers can't set headers on a WebSocket handshake or an
+			// EventSource, so the token may come as a query parameter instead.
+			sessionToken = r.URL.Query().Get("access_token")
+		}
 		if sessionToken == "" {
-			http.Error(w, "Unauthorized: No session token provided", http.StatusUnauthorized)
+			writeError(w, r, errUnauthorized("No session token provided"))
 			return
 		}
 
 		sessionToken = strings.TrimPrefix(sessionToken, "Bearer ")
 
-		ctx := r.Context()
-		authorized, token, err := descopeClient.Auth.ValidateSessionWithToken(ctx, sessionToken)
-		if err != nil || !authorized {
-			log.Printf("Session validation failed: %v", err)
-			http.Error(w, "Unauthorized: Invalid session token", http.StatusUnauthorized)
+		ctx, span := tracer.Start(r.Context(), "sessionValidationMiddleware")
+		defer span.End()
+
+		// Tooling without a browser session authenticates with an API key instead.
+		if strings.HasPrefix(sessionToken, apiKeyPrefix) {
+			keyCtx, err := authenticateAPIKey(ctx, sessionToken, r.Header.Get(tenantIDHeader))
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			span.SetAttributes(attribute.String("bss.tenant_id", tenantFromContext(keyCtx)), attribute.Bool("bss.api_key", true))
+			next.ServeHTTP(w, r.WithContext(keyCtx))
 			return
 		}
-		if descopeClient.Auth.ValidateRoles(context.Background(), token, []string{"Game Admin"}) {
-			isAnAdmin = true
-		} else {
-			isAnAdmin = false
+
+		// Tokens validated recently are taken from the session cache.
+		token, cached := sessions.get(sessionToken)
+		span.SetAttributes(attribute.Bool("bss.session_cached", cached))
+		if !cached {
+			validateCtx, validateSpan := tracer.Start(ctx, "descope.ValidateSessionWithToken")
+			authorized, validated, err := descopeClient.Auth.ValidateSessionWithToken(validateCtx, sessionToken)
+			if err != nil {
+				recordSpanError(validateSpan, err)
+			}
+			validateSpan.End()
+			if err != nil || !authorized {
+				slog.WarnContext(ctx, "session validation failed", "error", err)
+				writeError(w, r, errUnauthorized("Invalid session token"))
+				return
+			}
+			token = validated
+			sessions.put(sessionToken, token)
+		}
+		// Everything the request reads or writes is confined to one of the
+		// session's Descope tenants.
+		tenantID, err := resolveTenant(token, r.Header.Get(tenantIDHeader))
+		if err != nil {
+			writeError(w, r, err)
+			return
 		}
+		ctx = withTenant(ctx, tenantID)
+
+		// The configured roles this session holds in that tenant decide what
+		// it may do beyond its own checkpoints.
+		roles, projectRoles := tenantRoles(ctx, token, tenantID)
+		span.SetAttributes(attribute.String("bss.tenant_id", tenantID), attribute.StringSlice("bss.roles", roles))
 
 		userID := token.ID
-		// userRole := token.GetTenants()
-		// userRole := token.GetTenantValue()
-		// userRole := token.GetTenants()
 		if userID == "" {
-			http.Error(w, "Unauthorized: User ID not found in token", http.StatusUnauthorized)
+			writeError(w, r, errUnauthorized("User ID not found in token"))
 			return
 		}
 		
-		// For this example, we assume the player ID is the same as the user ID.
-		// In a real-world app, you would extract this from custom claims in the token.
-		playerID := userID
+		// The player is the profile named by X-Player-ID, or the account's
+		// default profile in the tenant, provisioned from the configured player ID
+		// claim (or the user ID) on the first request; cached with the session.
+		playerID, err := sessionPlayer(ctx, sessionToken, userID, claimedPlayerID(token), r.Header.Get(playerIDHeader))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-		// Store the user ID and teacher ID in the request's context
+		setLogPlayer(ctx, playerID, roles)
+
+		// Store the user ID, player ID, permissions and session expiry in the request's context
 		ctxWithUserID := context.WithValue(ctx, contextKeyUserID, userID)
 		ctxWithIDs := context.WithValue(ctxWithUserID, contextKeyPlayerID, playerID)
+		ctxWithGrants := context.WithValue(ctxWithIDs, contextKeyGrants, grantsForRoles(roles, projectRoles))
+		if token.Expiration > 0 {
+			ctxWithGrants = context.WithValue(ctxWithGrants, contextKeySessionExpires, time.Unix(token.Expiration, 0))
+		}
 		
-		next.ServeHTTP(w, r.WithContext(ctxWithIDs))
+		next.ServeHTTP(w, r.WithContext(ctxWithGrants))
 	})
 }
 
@@ -199,38 +401,63 @@ func createCheckpointAsAdmin(w http.ResponseWriter, r *http.Request) {
 	var playerCheckpoint Checkpoint
 	err := json.NewDecoder(r.Body).Decode(&playerCheckpoint)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, errBadBody(err))
 		return
 	}
- 	query := `INSERT INTO gameplay_checkpoints (user_name, checkpoint_data, player_id) VALUES ($1, $2) RETURNING id`
-	err = db.QueryRow(query, playerCheckpoint.Username, playerCheckpoint.CheckpointData).Scan(&playerCheckpoint.ID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating player checkpoint: %v", err), http.StatusInternalServerError)
+ 	query := `INSERT INTO gameplay_checkpoints (user_name, checkpoint_data, player_id, tenant_id, game_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_edited_at`
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkPlayerTenant(r.Context(), tx, playerCheckpoint.PlayerID); err != nil {
+			return err
+		}
+		if err := checkCheckpointForGame(r.Context(), tx, playerCheckpoint, true); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(r.Context(), query, playerCheckpoint.Username, playerCheckpoint.CheckpointData, playerCheckpoint.PlayerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&playerCheckpoint.ID, &playerCheckpoint.CreatedAt, &playerCheckpoint.LastEditedAt)
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(r.Context(), tx, auditCheckpointCreate, playerCheckpoint.ID, playerCheckpoint.PlayerID, nil, &playerCheckpoint); err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointCreated, playerCheckpoint)
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("creating checkpoint", err))
+		return
+	}
+	if err := attachPlayer(r.Context(), &playerCheckpoint); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint player", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(playerCheckpoint)
+	writeJSON(w, r, http.StatusCreated, playerCheckpoint)
 }
 
 // createStudent handles POST requests to create a new student record.
 func createCheckpointAsPlayer(w http.ResponseWriter, r *http.Request) {
 	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
 	if !ok || playerID == "" {
-		http.Error(w, "Forbidden: player ID not found in session", http.StatusForbidden)
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
 		return
 	}
 
 	var playerCheckpoint Checkpoint 
 	err := json.NewDecoder(r.Body).Decode(&playerCheckpoint)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, errBadBody(err))
 		return
 	}
 	
 	// Enforce that the playerCheckpoint being created is associated with the authenticated player.
-	playerCheckpoint.playerID = playerID
+	if playerCheckpoint.PlayerID != "" && playerCheckpoint.PlayerID != playerID {
+		writeError(w, r, errForbidden(codeNotOwner, "Checkpoints can only be created for your own player"))
+		return
+	}
+	playerCheckpoint.PlayerID = playerID
 
 	// 	ID             int       `json:"id"`
 	// Username       string    `json:"user_name"`
@@ -239,20 +466,35 @@ func createCheckpointAsPlayer(w http.ResponseWriter, r *http.Request) {
 	// LastEditedAt   time.Time `json:"last_edited_at"`
 	// playerID	   string    `json:"player_id"`
 
-	query := `INSERT INTO gameplay_checkpoints (user_name, checkpoint_data, player_id) VALUES ($1, $2, $3) RETURNING id`
-	err = db.QueryRow(query, playerCheckpoint.Username, playerCheckpoint.CheckpointData, playerCheckpoint.playerID).Scan(&playerCheckpoint.ID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating checkpoint: %v", err), http.StatusInternalServerError)
+	query := `INSERT INTO gameplay_checkpoints (user_name, checkpoint_data, player_id, tenant_id, game_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_edited_at`
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkCheckpointForGame(r.Context(), tx, playerCheckpoint, true); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(r.Context(), query, playerCheckpoint.Username, playerCheckpoint.CheckpointData, playerCheckpoint.PlayerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&playerCheckpoint.ID, &playerCheckpoint.CreatedAt, &playerCheckpoint.LastEditedAt)
+		if err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointCreated, playerCheckpoint)
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("creating checkpoint", err))
+		return
+	}
+	if err := attachPlayer(r.Context(), &playerCheckpoint); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint player", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(playerCheckpoint)
+	writeJSON(w, r, http.StatusCreated, playerCheckpoint)
 }
 
 func createCheckpoint(w http.ResponseWriter, r *http.Request){
-	if (isAnAdmin) {
+	if hasPermission(r.Context(), permCheckpointsWriteAny) {
 		createCheckpointAsAdmin(w, r)
 	} else {
 		createCheckpointAsPlayer(w, r)
@@ -265,63 +507,73 @@ func getCheckpointAsAdmin(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid myCheckpoint ID", http.StatusBadRequest)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
 	var myCheckpoint Checkpoint
 	// CHQ: Gemini AI added the two timestamp columns to the SELECT query
-	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at FROM gameplay_checkpoints WHERE id = $1`
-	row := db.QueryRow(query, id)
+	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, COALESCE(player_id, '') FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3`
+	row := db.QueryRowContext(r.Context(), query, id, tenantFromContext(r.Context()), gameIDFromContext(r.Context()))
     // CHQ: Gemini AI Added the two timestamp fields to the Scan function
-	err = row.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt)
+	err = row.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Checkpoint not found", http.StatusNotFound)
+		writeError(w, r, errCheckpointNotFound())
 		return
 	} else if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving myCheckpoint: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("retrieving checkpoint", err))
+		return
+	}
+	if err := recordAudit(r.Context(), db, auditCheckpointRead, id, myCheckpoint.PlayerID, nil, nil); err != nil {
+		writeError(w, r, errInternal("auditing checkpoint read", err))
+		return
+	}
+	if err := attachPlayer(r.Context(), &myCheckpoint); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint player", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(myCheckpoint)
+	writeJSON(w, r, http.StatusOK, myCheckpoint)
 }
 // getStudent handles GET requests to retrieve a single student by ID, but also checks for ownership.
 func getCheckpointAsPlayer(w http.ResponseWriter, r *http.Request) {
 	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
 	if !ok || playerID == "" {
-		http.Error(w, "Forbidden: player ID not found in session", http.StatusForbidden)
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
 		return
 	}
 	
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid player ID", http.StatusBadRequest)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
 	var myCheckpoint Checkpoint
 	// Ensure the checkpoint belongs to the authenticated player.
-	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, player_id FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2`
-	row := db.QueryRow(query, id, playerID)
+	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, player_id FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2 AND tenant_id = $3 AND game_id = $4`
+	row := db.QueryRowContext(r.Context(), query, id, playerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context()))
 
-	err = row.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.playerID) 
+	err = row.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID) 
 	if err == sql.ErrNoRows {
-		http.Error(w, "myCheckpoint not found or not owned by this player", http.StatusNotFound)
+		writeError(w, r, errCheckpointNotFound())
 		return
 	} else if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving myCheckpoint: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("retrieving checkpoint", err))
+		return
+	}
+	if err := attachPlayer(r.Context(), &myCheckpoint); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint player", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(myCheckpoint)
+	writeJSON(w, r, http.StatusOK, myCheckpoint)
 }
 
 
 func getCheckpoint(w http.ResponseWriter, r *http.Request){
-	if (isAnAdmin) {
+	if hasPermission(r.Context(), permCheckpointsReadAny) {
 		getCheckpointAsAdmin(w, r)
 	} else {
 		getCheckpointAsPlayer(w, r)
@@ -330,13 +582,18 @@ func getCheckpoint(w http.ResponseWriter, r *http.Request){
 
 
 // getAllCheckpointsAsAdmin handles GET requests to retrieve all myCheckpoint records.
-func getAllCheckpointsAsAdmin(w http.ResponseWriter) {
+func getAllCheckpointsAsAdmin(w http.ResponseWriter, r *http.Request) {
+	if err := recordAudit(r.Context(), db, auditCheckpointList, 0, "", nil, nil); err != nil {
+		writeError(w, r, errInternal("auditing checkpoint list", err))
+		return
+	}
+
 	var gameplayCheckpoints []Checkpoint
 	// CHQ: Gemini AI added the two timestamp columns to the SELECT query
-	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at FROM gameplay_checkpoints ORDER BY id`
-	rows, err := db.Query(query)
+	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, COALESCE(player_id, '') FROM gameplay_checkpoints WHERE tenant_id = $1 AND game_id = $2 ORDER BY id`
+	rows, err := db.QueryContext(r.Context(), query, tenantFromContext(r.Context()), gameIDFromContext(r.Context()))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving gameplay_checkpoints: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("retrieving gameplay_checkpoints", err))
 		return
 	}
 	defer rows.Close()
@@ -344,36 +601,39 @@ func getAllCheckpointsAsAdmin(w http.ResponseWriter) {
 	for rows.Next() {
 		var myCheckpoint Checkpoint
 		// CHQ: Gemini AI added the two timestamp fields to the Scan function
-		err := rows.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt)
+		err := rows.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID)
 		if err != nil {
-			log.Printf("Error scanning myCheckpoint row: %v", err)
+			slog.ErrorContext(r.Context(), "error scanning checkpoint row", "error", err)
 			continue
 		}
 		gameplayCheckpoints = append(gameplayCheckpoints, myCheckpoint)
 	}
 
 	if err = rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error iterating over myCheckpoint rows: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("iterating over checkpoint rows", err))
+		return
+	}
+	if err := attachPlayers(r.Context(), gameplayCheckpoints); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint players", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(gameplayCheckpoints)
+	writeJSON(w, r, http.StatusOK, gameplayCheckpoints)
 }
 
 func getAllCheckpointsAsPlayer(w http.ResponseWriter, r *http.Request) {
 	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
 	if !ok || playerID == "" {
-		http.Error(w, "Forbidden: player ID not found in session", http.StatusForbidden)
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
 		return
 	}
 
 	var gameplayCheckpoints []Checkpoint
 	// CHQ: Gemini AI added the two timestamp columns to the SELECT query
-	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at FROM gameplay_checkpoints WHERE player_id = $1 ORDER BY id`
-	rows, err := db.Query(query, playerID)
+	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, player_id FROM gameplay_checkpoints WHERE player_id = $1 AND tenant_id = $2 AND game_id = $3 ORDER BY id`
+	rows, err := db.QueryContext(r.Context(), query, playerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context()))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving gameplay_checkpoints: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("retrieving gameplay_checkpoints", err))
 		return
 	}
 	defer rows.Close()
@@ -381,27 +641,30 @@ func getAllCheckpointsAsPlayer(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var myCheckpoint Checkpoint
 		// CHQ: Gemini AI added the two timestamp fields to the Scan function
-		err := rows.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt)
+		err := rows.Scan(&myCheckpoint.ID, &myCheckpoint.Username, &myCheckpoint.CheckpointData, &myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID)
 		if err != nil {
-			log.Printf("Error scanning myCheckpoint row: %v", err)
+			slog.ErrorContext(r.Context(), "error scanning checkpoint row", "error", err)
 			continue
 		}
 		gameplayCheckpoints = append(gameplayCheckpoints, myCheckpoint)
 	}
 
 	if err = rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error iterating over myCheckpoint rows: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errInternal("iterating over checkpoint rows", err))
+		return
+	}
+	if err := attachPlayers(r.Context(), gameplayCheckpoints); err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint players", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(gameplayCheckpoints)
+	writeJSON(w, r, http.StatusOK, gameplayCheckpoints)
 }
 
 
 func getAllCheckpoints(w http.ResponseWriter, r *http.Request){
-	if (isAnAdmin) {
-		getAllCheckpointsAsAdmin(w)
+	if hasPermission(r.Context(), permCheckpointsReadAny) {
+		getAllCheckpointsAsAdmin(w, r)
 	} else {
 		getAllCheckpointsAsPlayer(w, r)
 	}
@@ -412,95 +675,112 @@ func updateCheckpointAsAdmin(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid myCheckpoint ID", http.StatusBadRequest)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
 	var myCheckpoint Checkpoint
 	err = json.NewDecoder(r.Body).Decode(&myCheckpoint)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, errBadBody(err))
 		return
 	}
 
 	if myCheckpoint.ID != 0 && myCheckpoint.ID != id {
-		http.Error(w, "ID in URL and request body do not match", http.StatusBadRequest)
+		writeError(w, r, errValidation("ID in URL and request body do not match"))
 		return
 	}
 	myCheckpoint.ID = id
     // Database automatically updates last_edited_at columns
-	query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3`
-	result, err := db.Exec(query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating myCheckpoint: %v", err), http.StatusInternalServerError)
+	query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3 AND tenant_id = $4 AND game_id = $5 RETURNING created_at, last_edited_at, COALESCE(player_id, '')`
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		before, err := lockCheckpoint(r.Context(), tx, myCheckpoint.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkCheckpointForGame(r.Context(), tx, myCheckpoint, false); err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(r.Context(), query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID)
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(r.Context(), tx, auditCheckpointUpdate, myCheckpoint.ID, myCheckpoint.PlayerID, before, &myCheckpoint); err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointUpdated, myCheckpoint)
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
 		return
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error checking rows affected: %v", err), http.StatusInternalServerError)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errCheckpointNotFound())
 		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "Checkpoint not found or no changes made", http.StatusNotFound)
+	} else if err != nil {
+		writeError(w, r, errInternal("updating checkpoint", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Checkpoint updated successfully"})
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Checkpoint updated successfully"})
 }
 
 func updateCheckpointAsPlayer(w http.ResponseWriter, r *http.Request) {
 	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
 	if !ok || playerID == "" {
-		http.Error(w, "Forbidden: player ID not found in session", http.StatusForbidden)
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
 		return
 	}
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid myCheckpoint ID", http.StatusBadRequest)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
 	var myCheckpoint Checkpoint
 	err = json.NewDecoder(r.Body).Decode(&myCheckpoint)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, errBadBody(err))
 		return
 	}
 
 	if myCheckpoint.ID != 0 && myCheckpoint.ID != id {
-		http.Error(w, "ID in URL and request body do not match", http.StatusBadRequest)
+		writeError(w, r, errValidation("ID in URL and request body do not match"))
 		return
 	}
 	myCheckpoint.ID = id
     // Database automatically updates last_edited_at columns
-	query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3 AND player_id = $4`
-	result, err := db.Exec(query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID, playerID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating myCheckpoint: %v", err), http.StatusInternalServerError)
+	query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3 AND player_id = $4 AND tenant_id = $5 AND game_id = $6 RETURNING created_at, last_edited_at, COALESCE(player_id, '')`
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkCheckpointForGame(r.Context(), tx, myCheckpoint, false); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(r.Context(), query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID, playerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&myCheckpoint.CreatedAt, &myCheckpoint.LastEditedAt, &myCheckpoint.PlayerID)
+		if err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointUpdated, myCheckpoint)
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
 		return
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error checking rows affected: %v", err), http.StatusInternalServerError)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errCheckpointNotFound())
 		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "Checkpoint not found or no changes made", http.StatusNotFound)
+	} else if err != nil {
+		writeError(w, r, errInternal("updating checkpoint", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Checkpoint updated successfully"})
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Checkpoint updated successfully"})
 }
 
 
 func updateCheckpoint(w http.ResponseWriter, r *http.Request){
-	if (isAnAdmin) {
+	if hasPermission(r.Context(), permCheckpointsWriteAny) {
 		updateCheckpointAsAdmin(w, r)
 	} else {
 		updateCheckpointAsPlayer(w, r)
@@ -531,7 +811,7 @@ func updateCheckpoint(w http.ResponseWriter, r *http.Request){
 // 	myCheckpoint.ID = id
 //     // Database automatically updates last_edited_at columns
 // 	query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3`
-// 	result, err := db.Exec(query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID)
+// 	result, err := db.ExecContext(r.Context(), query, myCheckpoint.Username, myCheckpoint.CheckpointData, myCheckpoint.ID)
 // 	if err != nil {
 // 		http.Error(w, fmt.Sprintf("Error updating myCheckpoint: %v", err), http.StatusInternalServerError)
 // 		return
@@ -556,68 +836,71 @@ func deleteCheckpointAsAdmin(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid myCheckpoint ID", http.StatusBadRequest)
-		return
-	}
-
-	query := `DELETE FROM gameplay_checkpoints WHERE id = $1`
-	result, err := db.Exec(query, id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting myCheckpoint: %v", err), http.StatusInternalServerError)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error checking rows affected: %v", err), http.StatusInternalServerError)
+	query := `DELETE FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3 RETURNING COALESCE(player_id, '')`
+	deleted := Checkpoint{ID: id}
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		before, err := lockCheckpoint(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(r.Context(), query, id, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&deleted.PlayerID); err != nil {
+			return err
+		}
+		if err := recordAudit(r.Context(), tx, auditCheckpointDelete, id, deleted.PlayerID, before, nil); err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointDeleted, deleted)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errCheckpointNotFound())
 		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "Checkpoint not found", http.StatusNotFound)
+	} else if err != nil {
+		writeError(w, r, errInternal("deleting checkpoint", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Checkpoint deleted successfully"})
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Checkpoint deleted successfully"})
 }
 
 func deleteCheckpointAsPlayer(w http.ResponseWriter, r *http.Request) {
 	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
 	if !ok || playerID == "" {
-		http.Error(w, "Forbidden: player ID not found in session", http.StatusForbidden)
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
 		return
 	}
 	
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid myCheckpoint ID", http.StatusBadRequest)
+		writeError(w, r, errValidation("Invalid checkpoint ID"))
 		return
 	}
 
-	query := `DELETE FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2`
-	result, err := db.Exec(query, id, playerID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting myCheckpoint: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error checking rows affected: %v", err), http.StatusInternalServerError)
+	query := `DELETE FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2 AND tenant_id = $3 AND game_id = $4 RETURNING COALESCE(player_id, '')`
+	deleted := Checkpoint{ID: id}
+	err = withTx(r.Context(), func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(r.Context(), query, id, playerID, tenantFromContext(r.Context()), gameIDFromContext(r.Context())).Scan(&deleted.PlayerID); err != nil {
+			return err
+		}
+		return writeOutboxEvent(r.Context(), tx, model.EventCheckpointDeleted, deleted)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errCheckpointNotFound())
 		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "Checkpoint not found", http.StatusNotFound)
+	} else if err != nil {
+		writeError(w, r, errInternal("deleting checkpoint", err))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Checkpoint deleted successfully"})
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Checkpoint deleted successfully"})
 }
 
 func deleteCheckpoint(w http.ResponseWriter, r *http.Request){
-	if (isAnAdmin) {
+	if hasPermission(r.Context(), permCheckpointsDeleteAny) {
 		deleteCheckpointAsAdmin(w, r)
 	} else {
 		deleteCheckpointAsPlayer(w, r)
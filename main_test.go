@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/descope/go-sdk/descope"
+	"github.com/descope/go-sdk/descope/client"
+)
+
+func TestMain(m *testing.M) {
+	// Sessions are seeded into the session cache, so the client only checks
+	// role claims locally and never calls Descope.
+	var err error
+	descopeClient, err = client.NewWithConfig(&client.Config{ProjectID: "P2testproject"})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// mockDB replaces db and the outbox dispatcher's connection with a sqlmock for
+// the test, and checks that every expected query ran.
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevEvents := db, events
+	db, events = conn, newOutboxDispatcher(conn, "")
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+		db, events = prevDB, prevEvents
+	})
+	return mock
+}
+
+// playerContext is the context the session middleware gives a plain player's
+// request in tenant.
+func playerContext(tenant, userID, playerID string) context.Context {
+	ctx := withTenant(context.Background(), tenant)
+	ctx = context.WithValue(ctx, contextKeyUserID, userID)
+	ctx = context.WithValue(ctx, contextKeyPlayerID, playerID)
+	return context.WithValue(ctx, contextKeyGrants, grants{Permissions: map[string]bool{}})
+}
+
+// fakeSession caches a validated session for token, as if Descope had
+// validated it: userID, a member of tenant with roles there.
+func fakeSession(t *testing.T, token, userID, tenant string, roles ...string) {
+	t.Helper()
+	tenantRoles := make([]any, len(roles))
+	for i, role := range roles {
+		tenantRoles[i] = role
+	}
+	sessions.put(token, &descope.Token{ID: userID, Claims: map[string]any{
+		descope.ClaimAuthorizedTenants: map[string]any{tenant: map[string]any{"roles": tenantRoles}},
+	}})
+	t.Cleanup(func() { sessions.dropUser(userID) })
+}
+
+// fakeProjectSession caches a validated session for token of userID, who
+// belongs to no tenant and holds roles project-wide.
+func fakeProjectSession(t *testing.T, token, userID string, roles ...string) {
+	t.Helper()
+	projectRoles := make([]any, len(roles))
+	for i, role := range roles {
+		projectRoles[i] = role
+	}
+	sessions.put(token, &descope.Token{ID: userID, Claims: map[string]any{"roles": projectRoles}})
+	t.Cleanup(func() { sessions.dropUser(userID) })
+}
+
+// expectDefaultPlayer expects the session middleware to resolve userID's
+// default player in tenant, and returns its ID.
+func expectDefaultPlayer(mock sqlmock.Sqlmock, tenant, userID string) string {
+	playerID := userID
+	if tenant != "" {
+		playerID = tenant + ":" + userID
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO players (id, user_id, tenant_id, is_default)`)).
+		WithArgs(playerID, userID, tenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(playerID))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE players SET last_seen`)).
+		WithArgs(playerID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	return playerID
+}
+
+// serve sends a request with token through the full router.
+func serve(method, path, token string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, body)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
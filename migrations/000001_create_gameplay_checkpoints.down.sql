@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS gameplay_checkpoints_last_edited_at ON gameplay_checkpoints;
+DROP FUNCTION IF EXISTS set_last_edited_at();
+DROP TABLE IF EXISTS gameplay_checkpoints;
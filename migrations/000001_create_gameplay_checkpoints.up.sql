@@ -0,0 +1,24 @@
+-- Baseline schema the server has always expected. IF NOT EXISTS keeps this a
+-- no-op on databases created before migrations were checked in.
+CREATE TABLE IF NOT EXISTS gameplay_checkpoints (
+    id              SERIAL PRIMARY KEY,
+    user_name       TEXT        NOT NULL,
+    checkpoint_data TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_edited_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    player_id       TEXT
+);
+
+CREATE INDEX IF NOT EXISTS gameplay_checkpoints_player_id_idx ON gameplay_checkpoints (player_id);
+
+CREATE OR REPLACE FUNCTION set_last_edited_at() RETURNS trigger AS $$
+BEGIN
+    NEW.last_edited_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS gameplay_checkpoints_last_edited_at ON gameplay_checkpoints;
+CREATE TRIGGER gameplay_checkpoints_last_edited_at
+    BEFORE UPDATE ON gameplay_checkpoints
+    FOR EACH ROW EXECUTE FUNCTION set_last_edited_at();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhook_deliveries_subscription_event_idx;
+DROP TABLE IF EXISTS checkpoint_outbox;
@@ -0,0 +1,18 @@
+-- Written in the same transaction as every checkpoint mutation, so an event
+-- exists exactly when its change was committed. dispatched_at is set once
+-- every durable sink has accepted the event.
+CREATE TABLE checkpoint_outbox (
+    id            BIGSERIAL PRIMARY KEY,
+    event_type    TEXT        NOT NULL,
+    player_id     TEXT        NOT NULL DEFAULT '',
+    checkpoint_id INTEGER     NOT NULL,
+    payload       JSONB       NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX checkpoint_outbox_pending_idx ON checkpoint_outbox (id) WHERE dispatched_at IS NULL;
+
+-- Outbox delivery is at-least-once; this makes recording a webhook delivery
+-- for a redelivered event a no-op.
+CREATE UNIQUE INDEX webhook_deliveries_subscription_event_idx ON webhook_deliveries (subscription_id, event_id);
@@ -0,0 +1,15 @@
+-- First response per (player, Idempotency-Key). status_code is NULL while the
+-- original request is still running.
+CREATE TABLE idempotency_keys (
+    player_id     TEXT        NOT NULL,
+    key           TEXT        NOT NULL,
+    request_hash  TEXT        NOT NULL,
+    status_code   INTEGER,
+    content_type  TEXT,
+    response_body BYTEA,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (player_id, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,20 @@
+-- Pending player-initiated erasures; the player confirms with the token whose
+-- hash is stored here.
+CREATE TABLE erasure_requests (
+    player_id  TEXT        PRIMARY KEY,
+    token_hash TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- One row per completed erasure. The player is recorded only as a SHA-256
+-- hash, so a later request can be checked against it without keeping the ID.
+CREATE TABLE erasure_tombstones (
+    id                  BIGSERIAL PRIMARY KEY,
+    player_hash         TEXT        NOT NULL,
+    initiated_by        TEXT        NOT NULL CHECK (initiated_by IN ('player', 'admin')),
+    admin_user_id       TEXT,
+    checkpoints_deleted INTEGER     NOT NULL,
+    erased_at           TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX erasure_tombstones_player_hash_idx ON erasure_tombstones (player_hash);
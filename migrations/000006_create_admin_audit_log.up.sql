@@ -0,0 +1,28 @@
+-- Append-only record of every privileged operation. before_hash and
+-- after_hash are SHA-256 digests of the checkpoint before and after the change.
+CREATE TABLE admin_audit_log (
+    id                   BIGSERIAL PRIMARY KEY,
+    actor_id             TEXT        NOT NULL,
+    role                 TEXT        NOT NULL,
+    action               TEXT        NOT NULL,
+    target_checkpoint_id INTEGER,
+    target_player_id     TEXT,
+    before_hash          TEXT,
+    after_hash           TEXT,
+    request_id           TEXT,
+    occurred_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX admin_audit_log_actor_idx ON admin_audit_log (actor_id, id);
+CREATE INDEX admin_audit_log_player_idx ON admin_audit_log (target_player_id, id);
+CREATE INDEX admin_audit_log_checkpoint_idx ON admin_audit_log (target_checkpoint_id, id);
+
+CREATE FUNCTION reject_audit_log_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER admin_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON admin_audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_log_change();
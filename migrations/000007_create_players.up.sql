@@ -0,0 +1,14 @@
+-- Player profiles, each owned by one auth-provider user. A user's default
+-- profile is provisioned on their first request, with the ID from the
+-- configured claim or, failing that, the user ID, which keeps checkpoints
+-- saved before this table existed with their owner.
+CREATE TABLE players (
+    id           TEXT        PRIMARY KEY,
+    user_id      TEXT        NOT NULL,
+    display_name TEXT        NOT NULL DEFAULT '',
+    is_default   BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX players_user_id_idx ON players (user_id);
+CREATE UNIQUE INDEX players_user_default_idx ON players (user_id) WHERE is_default;
@@ -0,0 +1,4 @@
+ALTER TABLE players
+    DROP COLUMN IF EXISTS avatar_url,
+    DROP COLUMN IF EXISTS preferences,
+    DROP COLUMN IF EXISTS last_seen;
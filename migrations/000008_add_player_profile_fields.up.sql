@@ -0,0 +1,7 @@
+-- Profile metadata, so clients read a player's name and avatar from one place
+-- instead of the user_name copied into each checkpoint. last_seen is updated
+-- at most once a minute by the session middleware.
+ALTER TABLE players
+    ADD COLUMN avatar_url  TEXT        NOT NULL DEFAULT '',
+    ADD COLUMN preferences JSONB       NOT NULL DEFAULT '{}',
+    ADD COLUMN last_seen   TIMESTAMPTZ;
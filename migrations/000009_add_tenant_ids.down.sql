@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS players_user_id_idx;
+CREATE INDEX players_user_id_idx ON players (user_id);
+DROP INDEX IF EXISTS players_user_default_idx;
+CREATE UNIQUE INDEX players_user_default_idx ON players (user_id) WHERE is_default;
+
+DROP INDEX IF EXISTS admin_audit_log_tenant_idx;
+DROP INDEX IF EXISTS gameplay_checkpoints_tenant_idx;
+
+ALTER TABLE admin_audit_log DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE webhook_subscriptions DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE checkpoint_outbox DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE players DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE gameplay_checkpoints DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,17 @@
+-- Every row that belongs to one organization carries its Descope tenant ID.
+-- The empty tenant is the single-tenant deployment and the home of all rows
+-- written before tenants existed.
+ALTER TABLE gameplay_checkpoints ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE players ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE checkpoint_outbox ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhook_subscriptions ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE admin_audit_log ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX gameplay_checkpoints_tenant_idx ON gameplay_checkpoints (tenant_id, id);
+CREATE INDEX admin_audit_log_tenant_idx ON admin_audit_log (tenant_id, id);
+
+-- An account has one default profile per tenant.
+DROP INDEX players_user_default_idx;
+CREATE UNIQUE INDEX players_user_default_idx ON players (tenant_id, user_id) WHERE is_default;
+DROP INDEX players_user_id_idx;
+CREATE INDEX players_user_id_idx ON players (tenant_id, user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS gameplay_checkpoints_game_player_idx;
+ALTER TABLE checkpoint_outbox DROP COLUMN IF EXISTS game_id;
+ALTER TABLE gameplay_checkpoints DROP COLUMN IF EXISTS game_id;
+DROP TABLE IF EXISTS games;
@@ -0,0 +1,20 @@
+-- Games (namespaces) sharing this backend, each with its own checkpoint
+-- rules. NULL limits mean unlimited; an empty origin list allows the
+-- server-wide origins only. bss is the default game, served by the original
+-- /api/gamecheckpoints routes and owner of every existing checkpoint.
+CREATE TABLE games (
+    id                         TEXT        PRIMARY KEY,
+    name                       TEXT        NOT NULL,
+    checkpoint_schema          JSONB,
+    max_checkpoints_per_player INTEGER,
+    max_checkpoint_bytes       INTEGER,
+    allowed_origins            TEXT[]      NOT NULL DEFAULT '{}',
+    created_at                 TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO games (id, name) VALUES ('bss', 'bss');
+
+ALTER TABLE gameplay_checkpoints ADD COLUMN game_id TEXT NOT NULL DEFAULT 'bss' REFERENCES games (id);
+ALTER TABLE checkpoint_outbox ADD COLUMN game_id TEXT NOT NULL DEFAULT 'bss';
+
+CREATE INDEX gameplay_checkpoints_game_player_idx ON gameplay_checkpoints (game_id, player_id);
@@ -0,0 +1,19 @@
+-- API keys let build pipelines and bots call /api without a Descope session.
+-- Only a SHA-256 hash of each key is stored; prefix is its first characters,
+-- kept so admins can tell keys apart. Revoked keys stay for the audit trail.
+CREATE TABLE api_keys (
+    id           BIGSERIAL   PRIMARY KEY,
+    key_hash     TEXT        NOT NULL UNIQUE,
+    prefix       TEXT        NOT NULL,
+    name         TEXT        NOT NULL,
+    tenant_id    TEXT        NOT NULL DEFAULT '',
+    permissions  TEXT[]      NOT NULL DEFAULT '{}',
+    player_id    TEXT,
+    created_by   TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_tenant_idx ON api_keys (tenant_id, id);
@@ -0,0 +1,11 @@
+-- Token buckets for RATE_LIMIT_STORE=postgres, shared by every instance. key
+-- names the limiter and who it limits; allowed is the outcome of the last
+-- request taken from the bucket.
+CREATE TABLE rate_limit_buckets (
+    key        TEXT             PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    allowed    BOOLEAN          NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX rate_limit_buckets_updated_at_idx ON rate_limit_buckets (updated_at);
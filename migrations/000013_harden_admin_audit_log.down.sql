@@ -0,0 +1 @@
+DROP TRIGGER IF EXISTS admin_audit_log_no_truncate ON admin_audit_log;
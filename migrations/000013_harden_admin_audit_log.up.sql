@@ -0,0 +1,5 @@
+-- TRUNCATE fires neither UPDATE nor DELETE triggers, so it is rejected on its
+-- own.
+CREATE TRIGGER admin_audit_log_no_truncate
+    BEFORE TRUNCATE ON admin_audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_log_change();
@@ -0,0 +1,7 @@
+-- Stored responses are only kept for retries, so they are dropped rather than
+-- merged back under the narrower key.
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (player_id, key);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS actor;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,9 @@
+-- Keys are scoped to the tenant and to the principal using them as well as the
+-- player, so an admin impersonating a player, or an API key acting as one,
+-- can't replay or collide with the player's own requests. actor is the user,
+-- API key or impersonating admin. Keys stored before this have no actor and
+-- are simply never matched again; they expire as usual.
+ALTER TABLE idempotency_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN actor TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, player_id, actor, key);
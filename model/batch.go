@@ -0,0 +1,44 @@
+package model
+
+// Batch modes: in BatchModeAtomic (the default) the first failing operation
+// rolls back the whole batch; in BatchModePerItem each operation succeeds or
+// fails on its own.
+const (
+	BatchModeAtomic  = "atomic"
+	BatchModePerItem = "per_item"
+)
+
+// Batch operation kinds.
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// BatchRequest is the body of POST /api/gamecheckpoints:batch.
+type BatchRequest struct {
+	Mode       string           `json:"mode,omitempty"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one create, update or delete. ID names the checkpoint to
+// update or delete; Checkpoint carries the fields to create or update.
+type BatchOperation struct {
+	Op         string      `json:"op"`
+	ID         int         `json:"id,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// BatchResult reports the outcome of the operation at the same index: the
+// HTTP status it would have had on its own, and either the resulting
+// checkpoint or the problem.
+type BatchResult struct {
+	Status     int         `json:"status"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	Error      *Problem    `json:"error,omitempty"`
+}
+
+// BatchResponse lists one result per operation, in request order.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
@@ -0,0 +1,20 @@
+// Package model holds the types shared by the server and the Go client: the
+// JSON shapes of API resources and of error responses.
+package model
+
+import "time"
+
+// Checkpoint represents a user record in the database.
+//
+// Username is deprecated: read the name from Player, the owning profile, which
+// responses fill in. When the profile has a display name it also replaces
+// Username in responses.
+type Checkpoint struct {
+	ID             int        `json:"id"`
+	Username       string     `json:"user_name"`
+	CheckpointData string     `json:"checkpoint_data"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastEditedAt   time.Time  `json:"last_edited_at"`
+	PlayerID       string     `json:"player_id"`
+	Player         *PlayerRef `json:"player,omitempty"`
+}
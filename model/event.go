@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Checkpoint lifecycle event types.
+const (
+	EventCheckpointCreated = "checkpoint.created"
+	EventCheckpointUpdated = "checkpoint.updated"
+	EventCheckpointDeleted = "checkpoint.deleted"
+)
+
+// CheckpointEvent describes a change to a checkpoint. Checkpoint holds the
+// state after the change; for deletions only its ID and PlayerID are set.
+// TenantID is the Descope tenant the checkpoint belongs to, if any, and
+// GameID the game it was saved in.
+type CheckpointEvent struct {
+	ID         int64      `json:"id"`
+	Type       string     `json:"type"`
+	PlayerID   string     `json:"player_id"`
+	TenantID   string     `json:"tenant_id,omitempty"`
+	GameID     string     `json:"game_id"`
+	Checkpoint Checkpoint `json:"checkpoint"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
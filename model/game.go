@@ -0,0 +1,24 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DefaultGameID is the game served by the original /api/gamecheckpoints
+// routes.
+const DefaultGameID = "bss"
+
+// Game is a namespace of checkpoints with its own rules. CheckpointSchema,
+// when set, is a JSON Schema that checkpoint_data must be JSON text matching.
+// Zero limits are unlimited; AllowedOrigins are front-ends allowed in
+// addition to the server-wide list.
+type Game struct {
+	ID                      string          `json:"id"`
+	Name                    string          `json:"name"`
+	CheckpointSchema        json.RawMessage `json:"checkpoint_schema,omitempty"`
+	MaxCheckpointsPerPlayer int             `json:"max_checkpoints_per_player,omitempty"`
+	MaxCheckpointBytes      int             `json:"max_checkpoint_bytes,omitempty"`
+	AllowedOrigins          []string        `json:"allowed_origins"`
+	CreatedAt               time.Time       `json:"created_at"`
+}
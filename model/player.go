@@ -0,0 +1,34 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Player is a player profile. UserID, the owning account, is only shown to
+// admins.
+type Player struct {
+	ID          string          `json:"id"`
+	UserID      string          `json:"user_id,omitempty"`
+	DisplayName string          `json:"display_name"`
+	AvatarURL   string          `json:"avatar_url"`
+	Preferences json.RawMessage `json:"preferences"`
+	Default     bool            `json:"default"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastSeen    *time.Time      `json:"last_seen,omitempty"`
+}
+
+// PlayerUpdate is a partial update of a profile; nil fields are left as they
+// are. Preferences, when given, replaces the stored object.
+type PlayerUpdate struct {
+	DisplayName *string         `json:"display_name,omitempty"`
+	AvatarURL   *string         `json:"avatar_url,omitempty"`
+	Preferences json.RawMessage `json:"preferences,omitempty"`
+}
+
+// PlayerRef is the part of a profile embedded in the checkpoints it owns.
+type PlayerRef struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
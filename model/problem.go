@@ -0,0 +1,54 @@
+package model
+
+// Error codes returned in the "code" member of every problem response. They
+// are part of the API contract: clients branch on them, so existing values
+// must never change meaning.
+const (
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotOwner           = "not_owner"
+	CodeValidationFailed   = "validation_failed"
+	CodeCheckpointNotFound = "checkpoint_not_found"
+	CodeWebhookNotFound    = "webhook_not_found"
+	CodeAPIKeyNotFound     = "api_key_not_found"
+	CodePlayerNotFound     = "player_not_found"
+	CodeGameNotFound       = "game_not_found"
+	// CodeGameExists means a game with the registered ID already exists;
+	// CodeGameInUse that a game cannot be deleted: it is the default game or
+	// still has checkpoints.
+	CodeGameExists = "game_exists"
+	CodeGameInUse  = "game_in_use"
+	// CodeUnsupportedSchema means a game's checkpoint_schema uses a JSON
+	// Schema keyword, or a form of one, that the server does not enforce.
+	CodeUnsupportedSchema = "unsupported_schema"
+	// CodePlayerHasCheckpoints means a profile still owns checkpoints and
+	// must be erased rather than deleted.
+	CodePlayerHasCheckpoints = "player_has_checkpoints"
+	CodeRouteNotFound        = "route_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeQuotaExceeded        = "quota_exceeded"
+	// CodeRateLimited means the caller sent requests faster than the route's
+	// rate limit; Retry-After says when to try again.
+	CodeRateLimited = "rate_limited"
+	// CodeIdempotencyKeyReused means the Idempotency-Key was already used for
+	// a different request; CodeIdempotencyInProgress that the first request
+	// with it has not finished yet and the retry should wait.
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	CodeInternal              = "internal_error"
+)
+
+// ProblemContentType is the RFC 9457 media type of error responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the application/problem+json body of every error response, with
+// the RFC 9457 members plus the "code" and "request_id" extensions.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
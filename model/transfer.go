@@ -0,0 +1,28 @@
+package model
+
+// Conflict policies for an import that preserves IDs, applied when a row with
+// the same ID already exists.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRenumber  = "renumber"
+)
+
+// ImportReport summarizes a checkpoint import. With DryRun set nothing was
+// written, but the counts are what the import would have done.
+type ImportReport struct {
+	DryRun      bool          `json:"dry_run"`
+	Created     int           `json:"created"`
+	Overwritten int           `json:"overwritten"`
+	Renumbered  int           `json:"renumbered"`
+	Skipped     int           `json:"skipped"`
+	Failed      int           `json:"failed"`
+	Errors      []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError describes a record that could not be imported. Line is the
+// 1-based line of the record in the input.
+type ImportError struct {
+	Line   int    `json:"line"`
+	Detail string `json:"detail"`
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSpecCoversEveryRoute(t *testing.T) {
+	if err := checkSpecCoverage(newRouter(), openAPISpec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpecCoverageReportsUndocumentedRoute(t *testing.T) {
+	router := newRouter()
+	router.HandleFunc("/api/undocumented", helloHandler).Methods(http.MethodPost)
+	err := checkSpecCoverage(router, openAPISpec)
+	if err == nil || !strings.Contains(err.Error(), "POST /api/undocumented") {
+		t.Fatalf("err = %v, want it to name POST /api/undocumented", err)
+	}
+}
+
+func TestDocsAssetsAreServedLocally(t *testing.T) {
+	for path, want := range map[string]int{
+		"/docs/swagger-ui-bundle.js": http.StatusOK,
+		"/docs/swagger-ui.css":       http.StatusOK,
+		"/docs/index.html":           http.StatusNotFound,
+	} {
+		if w := serve(http.MethodGet, path, "", nil); w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+	w := serve(http.MethodGet, "/docs", "", nil)
+	if strings.Contains(w.Body.String(), "://") {
+		t.Error("docs page loads assets from another host")
+	}
+}
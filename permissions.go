@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Permissions a role can grant. Without any of them a user is a plain player
+// who can only act on their own checkpoints.
+const (
+	permCheckpointsReadAny   = "checkpoints:read:any"
+	permCheckpointsWriteAny  = "checkpoints:write:any"
+	permCheckpointsDeleteAny = "checkpoints:delete:any"
+	permPlayersModerate      = "players:moderate"
+	permWebhooksManage       = "webhooks:manage"
+	permAuditRead            = "audit:read"
+	permGamesManage          = "games:manage"
+	permAPIKeysManage        = "apikeys:manage"
+	permSessionsManage       = "sessions:manage"
+	permPlayersImpersonate   = "players:impersonate"
+)
+
+var allPermissions = []string{
+	permCheckpointsReadAny,
+	permCheckpointsWriteAny,
+	permCheckpointsDeleteAny,
+	permPlayersModerate,
+	permWebhooksManage,
+	permAuditRead,
+	permGamesManage,
+	permAPIKeysManage,
+	permSessionsManage,
+	permPlayersImpersonate,
+}
+
+// Descope roles with a default permission mapping.
+const (
+	adminRole   = "Game Admin"
+	supportRole = "Support"
+)
+
+// defaultRolePermissions applies when no mapping is configured: admins may
+// do everything, support staff may read any checkpoint but not change it.
+var defaultRolePermissions = map[string][]string{
+	adminRole:   allPermissions,
+	supportRole: {permCheckpointsReadAny},
+}
+
+// parseRolePermissionsEnv sets *m from ROLE_PERMISSIONS, a JSON object from
+// role name to a list of permissions, e.g. {"Support":
+// ["checkpoints:read:any"]}, if it is set. It replaces the mapping entirely.
+func parseRolePermissionsEnv(m *map[string][]string) error {
+	s := os.Getenv("ROLE_PERMISSIONS")
+	if s == "" {
+		return nil
+	}
+	var v map[string][]string
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return fmt.Errorf("ROLE_PERMISSIONS: not a JSON object of role to permissions: %w", err)
+	}
+	*m = v
+	return nil
+}
+
+// validateRolePermissions reports every unknown permission in m.
+func validateRolePermissions(m map[string][]string) []error {
+	var errs []error
+	for _, role := range slices.Sorted(maps.Keys(m)) {
+		for _, p := range m[role] {
+			if !slices.Contains(allPermissions, p) {
+				errs = append(errs, fmt.Errorf("auth.role_permissions[%q]: unknown permission %q", role, p))
+			}
+		}
+	}
+	return errs
+}
+
+// projectPermissions act on state every tenant shares, so only roles held
+// project-wide, outside any tenant, grant them; a role granted inside a
+// tenant, or an API key, never does.
+var projectPermissions = []string{permGamesManage, permSessionsManage}
+
+// grants are the roles a session holds and the permissions they add up to.
+type grants struct {
+	Roles       []string
+	Permissions map[string]bool
+}
+
+const contextKeyGrants contextKey = "grants"
+
+// grantsForRoles combines the permissions of roles. Only the roles also in
+// projectRoles grant projectPermissions.
+func grantsForRoles(roles, projectRoles []string) grants {
+	g := grants{Roles: roles, Permissions: make(map[string]bool)}
+	for _, role := range roles {
+		for _, p := range config.Auth.RolePermissions[role] {
+			if slices.Contains(projectPermissions, p) && !slices.Contains(projectRoles, role) {
+				continue
+			}
+			g.Permissions[p] = true
+		}
+	}
+	return g
+}
+
+// configuredRoles lists the roles in the mapping, in a stable order.
+func configuredRoles() []string {
+	roles := make([]string, 0, len(config.Auth.RolePermissions))
+	for role := range config.Auth.RolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func grantsFromContext(ctx context.Context) grants {
+	g, _ := ctx.Value(contextKeyGrants).(grants)
+	return g
+}
+
+// hasPermission reports whether the session in ctx holds permission p.
+func hasPermission(ctx context.Context, p string) bool {
+	return grantsFromContext(ctx).Permissions[p]
+}
+
+// rolesString is the session's roles as one comma-separated value, for logs
+// and the audit log.
+func rolesString(ctx context.Context) string {
+	return strings.Join(grantsFromContext(ctx).Roles, ",")
+}
+
+// requirePermission rejects requests whose session lacks permission p. It
+// must run after sessionValidationMiddleware.
+func requirePermission(p string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r.Context(), p) {
+			writeError(w, r, errForbidden(codeForbidden, "This operation requires the "+p+" permission"))
+			return
+		}
+		h(w, r)
+	}
+}
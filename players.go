@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/descope/go-sdk/descope"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"studentbackendgosql/model"
+)
+
+const (
+	// playerIDHeader selects which of the account's player profiles a request
+	// acts as; without it the default profile is used.
+	playerIDHeader = "X-Player-ID"
+	// maxPlayersPerUser bounds the profiles one account may own.
+	maxPlayersPerUser = 10
+	// maxDisplayNameLength bounds a profile's display name, in characters.
+	maxDisplayNameLength = 64
+)
+
+// Player is a player profile. It lives in model so the Go client shares it.
+type Player = model.Player
+
+// playerColumns are the columns scanned by scanPlayer, in order.
+const playerColumns = `id, user_id, display_name, avatar_url, preferences, is_default, created_at, last_seen`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPlayer(row rowScanner) (Player, error) {
+	var p Player
+	var prefs []byte
+	var lastSeen sql.NullTime
+	err := row.Scan(&p.ID, &p.UserID, &p.DisplayName, &p.AvatarURL, &prefs, &p.Default, &p.CreatedAt, &lastSeen)
+	p.Preferences = prefs
+	if lastSeen.Valid {
+		p.LastSeen = &lastSeen.Time
+	}
+	return p, err
+}
+
+// claimedPlayerID reads the configured player ID claim from token.
+func claimedPlayerID(token *descope.Token) string {
+	if config.Auth.PlayerIDClaim == "" {
+		return ""
+	}
+	s, _ := token.CustomClaim(config.Auth.PlayerIDClaim).(string)
+	return s
+}
+
+// resolvePlayer returns the player a request from userID acts as: selected if
+// given and owned by the account in the request's tenant, otherwise the
+// account's default profile there, which is provisioned here on the account's
+// first request.
+func resolvePlayer(ctx context.Context, userID, claimed, selected string) (string, error) {
+	tenantID := tenantFromContext(ctx)
+	playerID := selected
+	if selected != "" {
+		var owned bool
+		query := `SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND user_id = $2 AND tenant_id = $3)`
+		if err := db.QueryRowContext(ctx, query, selected, userID, tenantID).Scan(&owned); err != nil {
+			return "", errInternal("looking up player", err)
+		}
+		if !owned {
+			return "", errForbidden(codeNotOwner, playerIDHeader+" is not one of your player profiles")
+		}
+	} else {
+		defaultID := claimed
+		if defaultID == "" {
+			defaultID = userID
+		}
+		// Player IDs are unique across tenants; the empty tenant keeps the
+		// bare ID so checkpoints from before tenants stay owned.
+		if tenantID != "" {
+			defaultID = tenantID + ":" + defaultID
+		}
+		// The insert is a no-op once the account has a default profile, so the
+		// claim only matters for the first request.
+		query := `WITH provisioned AS (
+				INSERT INTO players (id, user_id, tenant_id, is_default) VALUES ($1, $2, $3, true) ON CONFLICT DO NOTHING RETURNING id
+			)
+			SELECT id FROM provisioned
+			UNION ALL
+			SELECT id FROM players WHERE tenant_id = $3 AND user_id = $2 AND is_default
+			LIMIT 1`
+		if err := db.QueryRowContext(ctx, query, defaultID, userID, tenantID).Scan(&playerID); err == sql.ErrNoRows {
+			// defaultID already belongs to another account.
+			return "", errForbidden(codeForbidden, "No player profile could be provisioned for this account")
+		} else if err != nil {
+			return "", errInternal("provisioning player", err)
+		}
+	}
+
+	// Sessions answered by sessionPlayer from the cache don't get here, and
+	// without the cache last_seen is kept to the minute, so most requests
+	// don't write.
+	touch := `UPDATE players SET last_seen = now() WHERE id = $1 AND (last_seen IS NULL OR last_seen < now() - interval '1 minute')`
+	if _, err := db.ExecContext(ctx, touch, playerID); err != nil {
+		slog.WarnContext(ctx, "updating player last_seen", "error", err)
+	}
+	return playerID, nil
+}
+
+// sessionPlayer is resolvePlayer for a request with sessionToken, cached with
+// the session so that only the first request of a cached session reads or
+// writes players.
+func sessionPlayer(ctx context.Context, sessionToken, userID, claimed, selected string) (string, error) {
+	key := playerCacheKey(tenantFromContext(ctx), selected)
+	if playerID, ok := sessions.player(sessionToken, key); ok {
+		return playerID, nil
+	}
+	playerID, err := resolvePlayer(ctx, userID, claimed, selected)
+	if err != nil {
+		return "", err
+	}
+	sessions.putPlayer(sessionToken, key, playerID)
+	return playerID, nil
+}
+
+// forgetDeletedPlayer stops cached sessions acting as playerID, whose profile
+// has been deleted; their next request resolves a player again.
+func forgetDeletedPlayer(ctx context.Context, playerID string) {
+	if err := sessions.forgetPlayer(ctx, playerID); err != nil {
+		slog.WarnContext(ctx, "forgetting deleted player in session caches", "error", err)
+	}
+}
+
+// playerCacheKey identifies what a session's player was resolved from: the
+// tenant and the selected profile, if any.
+func playerCacheKey(tenantID, selected string) string {
+	return tenantID + "\x00" + selected
+}
+
+// attachPlayers fills in the owning profile of each checkpoint for a
+// response. A profile's display name also replaces the checkpoint's
+// deprecated user_name, so clients that still read it see the current name.
+func attachPlayers(ctx context.Context, cps []Checkpoint) error {
+	var ids []string
+	for _, cp := range cps {
+		if cp.PlayerID != "" {
+			ids = append(ids, cp.PlayerID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, display_name, avatar_url FROM players WHERE id = ANY($1) AND tenant_id = $2`, pq.Array(ids), tenantFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	refs := make(map[string]*model.PlayerRef)
+	for rows.Next() {
+		var ref model.PlayerRef
+		if err := rows.Scan(&ref.ID, &ref.DisplayName, &ref.AvatarURL); err != nil {
+			return err
+		}
+		refs[ref.ID] = &ref
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range cps {
+		ref := refs[cps[i].PlayerID]
+		if ref == nil {
+			continue
+		}
+		cps[i].Player = ref
+		if ref.DisplayName != "" {
+			cps[i].Username = ref.DisplayName
+		}
+	}
+	return nil
+}
+
+// attachPlayer is attachPlayers for a single checkpoint.
+func attachPlayer(ctx context.Context, cp *Checkpoint) error {
+	cps := []Checkpoint{*cp}
+	if err := attachPlayers(ctx, cps); err != nil {
+		return err
+	}
+	*cp = cps[0]
+	return nil
+}
+
+// applyPlayerUpdate validates u and writes it to player id, returning the
+// updated profile. ownerID, if set, must own the profile, which must be in
+// the request's tenant.
+func applyPlayerUpdate(ctx context.Context, id, ownerID string, u model.PlayerUpdate) (Player, error) {
+	if err := validatePlayerUpdate(u); err != nil {
+		return Player{}, err
+	}
+	var prefs []byte
+	if u.Preferences != nil {
+		prefs = u.Preferences
+	}
+	query := `UPDATE players SET display_name = COALESCE($1, display_name), avatar_url = COALESCE($2, avatar_url),
+			preferences = COALESCE($3::jsonb, preferences)
+		WHERE id = $4 AND ($5 = '' OR user_id = $5) AND tenant_id = $6
+		RETURNING ` + playerColumns
+	p, err := scanPlayer(db.QueryRowContext(ctx, query, u.DisplayName, u.AvatarURL, prefs, id, ownerID, tenantFromContext(ctx)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, errPlayerNotFound()
+	} else if err != nil {
+		return Player{}, errInternal("updating player", err)
+	}
+	return p, nil
+}
+
+func validatePlayerUpdate(u model.PlayerUpdate) *apiError {
+	if u.DisplayName != nil && utf8.RuneCountInString(*u.DisplayName) > maxDisplayNameLength {
+		return errValidation(fmt.Sprintf("display_name may be at most %d characters", maxDisplayNameLength))
+	}
+	if u.AvatarURL != nil && *u.AvatarURL != "" {
+		if parsed, err := url.Parse(*u.AvatarURL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return errValidation("avatar_url must be an absolute http or https URL")
+		}
+	}
+	if u.Preferences != nil {
+		var obj map[string]any
+		if err := json.Unmarshal(u.Preferences, &obj); err != nil || obj == nil {
+			return errValidation("preferences must be a JSON object")
+		}
+	}
+	return nil
+}
+
+// listPlayers handles GET /api/players, listing the account's player profiles.
+func listPlayers(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(contextKeyUserID).(string)
+	queryPlayers(w, r, `user_id = $2 ORDER BY is_default DESC, created_at`, userID)
+}
+
+// createPlayer handles POST /api/players, adding another player profile to
+// the account. Select it on later requests with the X-Player-ID header.
+func createPlayer(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(contextKeyUserID).(string)
+	insertPlayer(w, r, userID)
+}
+
+// getMyPlayer handles GET /api/players/me, the profile the request acts as.
+func getMyPlayer(w http.ResponseWriter, r *http.Request) {
+	playerID, _ := r.Context().Value(contextKeyPlayerID).(string)
+	p, err := findPlayer(r.Context(), playerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errPlayerNotFound())
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("retrieving player", err))
+		return
+	}
+	p.UserID = ""
+	writeJSON(w, r, http.StatusOK, p)
+}
+
+// updateMyPlayer handles PATCH /api/players/me.
+func updateMyPlayer(w http.ResponseWriter, r *http.Request) {
+	playerID, _ := r.Context().Value(contextKeyPlayerID).(string)
+	userID, _ := r.Context().Value(contextKeyUserID).(string)
+
+	var u model.PlayerUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	p, err := applyPlayerUpdate(r.Context(), playerID, userID, u)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p.UserID = ""
+	writeJSON(w, r, http.StatusOK, p)
+}
+
+// listPlayersAsAdmin handles GET /api/admin/players, the profiles in the
+// admin's tenant, optionally filtered by ?user_id=.
+func listPlayersAsAdmin(w http.ResponseWriter, r *http.Request) {
+	queryPlayers(w, r, `($2 = '' OR user_id = $2) ORDER BY user_id, is_default DESC, created_at`, r.URL.Query().Get("user_id"))
+}
+
+// createPlayerAsAdmin handles POST /api/admin/players, adding a profile to the
+// account named by user_id in the body.
+func createPlayerAsAdmin(w http.ResponseWriter, r *http.Request) {
+	insertPlayer(w, r, "")
+}
+
+// getPlayerAsAdmin handles GET /api/admin/players/{playerID}.
+func getPlayerAsAdmin(w http.ResponseWriter, r *http.Request) {
+	p, err := findPlayer(r.Context(), mux.Vars(r)["playerID"])
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errPlayerNotFound())
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("retrieving player", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, p)
+}
+
+// updatePlayerAsAdmin handles PATCH /api/admin/players/{playerID}.
+func updatePlayerAsAdmin(w http.ResponseWriter, r *http.Request) {
+	var u model.PlayerUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	p, err := applyPlayerUpdate(r.Context(), mux.Vars(r)["playerID"], "", u)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, p)
+}
+
+// deletePlayerAsAdmin handles DELETE /api/admin/players/{playerID}. Only
+// profiles without checkpoints can be deleted; the others must be erased
+// through /api/admin/players/{playerID}/data. A deleted default profile is
+// provisioned again on the account's next request.
+func deletePlayerAsAdmin(w http.ResponseWriter, r *http.Request) {
+	playerID := mux.Vars(r)["playerID"]
+	tenantID := tenantFromContext(r.Context())
+	query := `DELETE FROM players WHERE id = $1 AND tenant_id = $2 AND NOT EXISTS (SELECT 1 FROM gameplay_checkpoints WHERE player_id = $1) RETURNING id`
+	var deleted string
+	err := db.QueryRowContext(r.Context(), query, playerID, tenantID).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		if err := db.QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id = $2)`, playerID, tenantID).Scan(&exists); err != nil {
+			writeError(w, r, errInternal("looking up player", err))
+			return
+		}
+		if !exists {
+			writeError(w, r, errPlayerNotFound())
+			return
+		}
+		writeError(w, r, &apiError{Status: http.StatusConflict, Code: codePlayerHasCheckpoints, Detail: "The player still owns checkpoints; erase their data instead"})
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("deleting player", err))
+		return
+	}
+	forgetDeletedPlayer(r.Context(), deleted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findPlayer reads profile id in the request's tenant.
+func findPlayer(ctx context.Context, id string) (Player, error) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE id = $1 AND tenant_id = $2`
+	return scanPlayer(db.QueryRowContext(ctx, query, id, tenantFromContext(ctx)))
+}
+
+// queryPlayers writes the profiles in the request's tenant matching cond,
+// which refers to arg as $2.
+func queryPlayers(w http.ResponseWriter, r *http.Request, cond, arg string) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE tenant_id = $1 AND ` + cond
+	rows, err := db.QueryContext(r.Context(), query, tenantFromContext(r.Context()), arg)
+	if err != nil {
+		writeError(w, r, errInternal("retrieving players", err))
+		return
+	}
+	defer rows.Close()
+
+	admin := hasPermission(r.Context(), permPlayersModerate)
+	players := []Player{}
+	for rows.Next() {
+		p, err := scanPlayer(rows)
+		if err != nil {
+			writeError(w, r, errInternal("scanning player row", err))
+			return
+		}
+		if !admin {
+			p.UserID = ""
+		}
+		players = append(players, p)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over player rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, players)
+}
+
+// insertPlayer creates a non-default profile for userID, or, when userID is
+// empty (admins), for the user_id in the body.
+func insertPlayer(w http.ResponseWriter, r *http.Request, userID string) {
+	var body struct {
+		model.PlayerUpdate
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	admin := userID == ""
+	if admin {
+		if body.UserID == "" {
+			writeError(w, r, errValidation("user_id is required"))
+			return
+		}
+		userID = body.UserID
+	}
+	if err := validatePlayerUpdate(body.PlayerUpdate); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var displayName, avatarURL string
+	if body.DisplayName != nil {
+		displayName = *body.DisplayName
+	}
+	if body.AvatarURL != nil {
+		avatarURL = *body.AvatarURL
+	}
+	var prefs []byte
+	if body.Preferences != nil {
+		prefs = body.Preferences
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	id := "p_" + hex.EncodeToString(b[:])
+
+	// Concurrent creates may overshoot the limit slightly; it only guards
+	// against runaway clients.
+	query := `INSERT INTO players (id, user_id, display_name, avatar_url, preferences, tenant_id)
+		SELECT $1, $2, $3, $4, COALESCE($5::jsonb, '{}'), $7 WHERE (SELECT count(*) FROM players WHERE user_id = $2 AND tenant_id = $7) < $6
+		RETURNING ` + playerColumns
+	p, err := scanPlayer(db.QueryRowContext(r.Context(), query, id, userID, displayName, avatarURL, prefs, maxPlayersPerUser, tenantFromContext(r.Context())))
+	if err == sql.ErrNoRows {
+		writeError(w, r, errForbidden(codeQuotaExceeded, fmt.Sprintf("An account may own at most %d player profiles", maxPlayersPerUser)))
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("creating player", err))
+		return
+	}
+	if !admin {
+		p.UserID = ""
+	}
+
+	writeJSON(w, r, http.StatusCreated, p)
+}
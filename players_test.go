@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// The player resolved for a session is cached with it, so only the session's
+// first request provisions; once the player is deleted it is resolved again.
+func TestSessionPlayerIsCached(t *testing.T) {
+	fakeSession(t, "tok-a", "user-a", "tenant-a")
+	mock := mockDB(t)
+	getMe := func(playerID string) {
+		t.Helper()
+		mock.ExpectQuery(regexp.QuoteMeta(`FROM players WHERE id = $1 AND tenant_id = $2`)).
+			WithArgs(playerID, "tenant-a").
+			WillReturnRows(sqlmock.NewRows(nil))
+		if w := serve(http.MethodGet, "/api/players/me", "tok-a", nil); w.Code != http.StatusNotFound {
+			t.Fatalf("status %d, want 404: %s", w.Code, w.Body)
+		}
+	}
+
+	playerID := expectDefaultPlayer(mock, "tenant-a", "user-a")
+	getMe(playerID)
+	getMe(playerID)
+
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(playerRevocationChannel, playerID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	forgetDeletedPlayer(context.Background(), playerID)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	getMe(playerID)
+}
+
+func TestUpdatePlayerRejectsMalformedJSON(t *testing.T) {
+	fakeSession(t, "tok-a", "user-a", "tenant-a")
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+
+	w := serve(http.MethodPatch, "/api/players/me", "tok-a", strings.NewReader(`{"display_name":`))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Request body is not valid JSON") {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+}
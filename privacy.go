@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"studentbackendgosql/model"
+)
+
+// erasureConfirmationTTL is how long a player has to confirm an erasure request.
+const erasureConfirmationTTL = 15 * time.Minute
+
+// Who started an erasure, as recorded in its tombstone.
+const (
+	erasureByPlayer = "player"
+	erasureByAdmin  = "admin"
+)
+
+// playerAccount is the account metadata included in a data export.
+type playerAccount struct {
+	PlayerID   string    `json:"player_id"`
+	UserID     string    `json:"user_id"`
+	Roles      []string  `json:"roles"`
+	Profile    Player    `json:"profile"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ErasureRequest is returned when a player asks for erasure; the token must be
+// sent back to confirm before ExpiresAt.
+type ErasureRequest struct {
+	ConfirmationToken string    `json:"confirmation_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// ErasureReport describes a completed erasure.
+type ErasureReport struct {
+	CheckpointsDeleted int       `json:"checkpoints_deleted"`
+	APIKeysRevoked     int       `json:"api_keys_revoked"`
+	ErasedAt           time.Time `json:"erased_at"`
+}
+
+// exportPlayerData handles GET /api/me/export. It returns a zip archive with
+// the player's account metadata and profile, their checkpoints, and the change history
+// still held in the outbox (the revisions of each checkpoint).
+func exportPlayerData(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
+	if !ok || playerID == "" {
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+		return
+	}
+	userID, _ := r.Context().Value(contextKeyUserID).(string)
+	ctx := r.Context()
+	tenantID := tenantFromContext(ctx)
+
+	checkpoints := []Checkpoint{}
+	rows, err := db.QueryContext(ctx, `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, COALESCE(player_id, '') FROM gameplay_checkpoints WHERE player_id = $1 AND tenant_id = $2 ORDER BY id`, playerID, tenantID)
+	if err != nil {
+		writeError(w, r, errInternal("retrieving checkpoints for export", err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.ID, &cp.Username, &cp.CheckpointData, &cp.CreatedAt, &cp.LastEditedAt, &cp.PlayerID); err != nil {
+			writeError(w, r, errInternal("scanning checkpoint row", err))
+			return
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over checkpoint rows", err))
+		return
+	}
+
+	profile, err := findPlayer(ctx, playerID)
+	if err != nil {
+		writeError(w, r, errInternal("retrieving player profile for export", err))
+		return
+	}
+	profile.UserID = ""
+
+	history, err := events.load(ctx, `SELECT id, event_type, player_id, tenant_id, game_id, payload, created_at FROM checkpoint_outbox WHERE player_id = $1 AND tenant_id = $2 ORDER BY id`, playerID, tenantID)
+	if err != nil {
+		writeError(w, r, errInternal("retrieving checkpoint history for export", err))
+		return
+	}
+	if history == nil {
+		history = []model.CheckpointEvent{}
+	}
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"account.json", playerAccount{PlayerID: playerID, UserID: userID, Roles: grantsFromContext(ctx).Roles, Profile: profile, ExportedAt: time.Now().UTC()}},
+		{"checkpoints.json", checkpoints},
+		{"history.json", history},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="bss-player-data.zip"`)
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
+
+// requestErasure handles POST /api/me/erasure, the first step of a
+// player-initiated erasure. Nothing is deleted until the returned token is
+// confirmed; a new request replaces any pending one.
+func requestErasure(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
+	if !ok || playerID == "" {
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+		return
+	}
+
+	var b [32]byte
+	rand.Read(b[:])
+	req := ErasureRequest{ConfirmationToken: hex.EncodeToString(b[:]), ExpiresAt: time.Now().Add(erasureConfirmationTTL).UTC()}
+
+	query := `INSERT INTO erasure_requests (player_id, token_hash, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (player_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at`
+	if _, err := db.ExecContext(r.Context(), query, playerID, hashToken(req.ConfirmationToken), req.ExpiresAt); err != nil {
+		writeError(w, r, errInternal("recording erasure request", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusAccepted, req)
+}
+
+// confirmErasure handles POST /api/me/erasure/confirm, erasing the player's
+// data if the body carries the token from a live erasure request.
+func confirmErasure(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
+	if !ok || playerID == "" {
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+		return
+	}
+
+	var body struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ConfirmationToken == "" {
+		writeError(w, r, errValidation("confirmation_token is required"))
+		return
+	}
+
+	var report ErasureReport
+	err := withTx(r.Context(), func(tx *sql.Tx) error {
+		var confirmed bool
+		query := `DELETE FROM erasure_requests WHERE player_id = $1 AND token_hash = $2 AND expires_at > now() RETURNING true`
+		if err := tx.QueryRowContext(r.Context(), query, playerID, hashToken(body.ConfirmationToken)).Scan(&confirmed); err != nil {
+			return err
+		}
+		var err error
+		report, err = erasePlayer(r.Context(), tx, playerID, erasureByPlayer, "")
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, errValidation("The confirmation token is invalid or has expired; request erasure again"))
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("erasing player data", err))
+		return
+	}
+	forgetDeletedPlayer(r.Context(), playerID)
+
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// erasePlayerData handles DELETE /api/admin/players/{playerID}/data, erasing
+// a player's data immediately on an admin's authority. Players of other
+// tenants are refused, and players with neither a profile nor checkpoints in
+// the tenant are not found.
+func erasePlayerData(w http.ResponseWriter, r *http.Request) {
+	playerID := mux.Vars(r)["playerID"]
+	adminUserID, _ := r.Context().Value(contextKeyUserID).(string)
+
+	var report ErasureReport
+	err := withTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkPlayerTenant(r.Context(), tx, playerID); err != nil {
+			return err
+		}
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id = $2)
+			OR EXISTS (SELECT 1 FROM gameplay_checkpoints WHERE player_id = $1 AND tenant_id = $2)`
+		if err := tx.QueryRowContext(r.Context(), query, playerID, tenantFromContext(r.Context())).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errPlayerNotFound()
+		}
+		var err error
+		report, err = erasePlayer(r.Context(), tx, playerID, erasureByAdmin, adminUserID)
+		return err
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("erasing player data", err))
+		return
+	}
+	forgetDeletedPlayer(r.Context(), playerID)
+
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// erasePlayer removes or anonymizes every row keyed by playerID in ctx's
+// tenant inside tx, revokes the API keys acting as the player and records a
+// tombstone. Earlier events and webhook payloads keep only the
+// checkpoint ID, and the deletions are published the same way, without the
+// player ID, so webhook receivers can drop their copies. The admin audit log
+// keeps the player ID: it is append-only, and it is the record of who looked
+// at or changed the player's data, which erasure must not destroy.
+func erasePlayer(ctx context.Context, tx *sql.Tx, playerID, initiatedBy, adminUserID string) (ErasureReport, error) {
+	var report ErasureReport
+
+	tenantID := tenantFromContext(ctx)
+	rows, err := tx.QueryContext(ctx, `DELETE FROM gameplay_checkpoints WHERE player_id = $1 AND tenant_id = $2 RETURNING id, game_id`, playerID, tenantID)
+	if err != nil {
+		return report, err
+	}
+	// Erasure covers the player's checkpoints in every game.
+	type erased struct {
+		id     int
+		gameID string
+	}
+	var deleted []erased
+	for rows.Next() {
+		var e erased
+		if err := rows.Scan(&e.id, &e.gameID); err != nil {
+			rows.Close()
+			return report, err
+		}
+		deleted = append(deleted, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	anonymize := []string{
+		`UPDATE checkpoint_outbox SET player_id = '', payload = jsonb_build_object('id', checkpoint_id) WHERE player_id = $1 AND tenant_id = $2`,
+		`UPDATE webhook_deliveries SET payload = payload || jsonb_build_object('player_id', '', 'checkpoint', jsonb_build_object('id', payload->'checkpoint'->'id'))
+			WHERE payload->>'player_id' = $1 AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE tenant_id = $2)`,
+		`DELETE FROM players WHERE id = $1 AND tenant_id = $2`,
+	}
+	for _, query := range anonymize {
+		if _, err := tx.ExecContext(ctx, query, playerID, tenantID); err != nil {
+			return report, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE api_keys SET revoked_at = now() WHERE player_id = $1 AND tenant_id = $2 AND revoked_at IS NULL`, playerID, tenantID)
+	if err != nil {
+		return report, err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return report, err
+	}
+	report.APIKeysRevoked = int(revoked)
+
+	// Player IDs are unique across tenants, so these need no tenant filter.
+	for _, query := range []string{
+		`DELETE FROM idempotency_keys WHERE player_id = $1`,
+		`DELETE FROM erasure_requests WHERE player_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, playerID); err != nil {
+			return report, err
+		}
+	}
+
+	for _, e := range deleted {
+		if err := writeOutboxEvent(withGame(ctx, e.gameID), tx, model.EventCheckpointDeleted, Checkpoint{ID: e.id}); err != nil {
+			return report, err
+		}
+	}
+
+	report.CheckpointsDeleted = len(deleted)
+	query := `INSERT INTO erasure_tombstones (player_hash, initiated_by, admin_user_id, checkpoints_deleted) VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING erased_at`
+	if err := tx.QueryRowContext(ctx, query, hashToken(playerID), initiatedBy, adminUserID, report.CheckpointsDeleted).Scan(&report.ErasedAt); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// hashToken returns the hex SHA-256 of s, for values that must be matched
+// later but not stored.
+func hashToken(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
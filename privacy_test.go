@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"studentbackendgosql/model"
+)
+
+func TestExportPlayerDataIncludesHistory(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM gameplay_checkpoints WHERE player_id = \$1 AND tenant_id = \$2`).
+		WithArgs("t1:p1", "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name", "checkpoint_data", "created_at", "last_edited_at", "player_id"}).
+			AddRow(7, "ada", "level 3", now, now, "t1:p1"))
+	mock.ExpectQuery(`FROM players WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs("t1:p1", "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "display_name", "avatar_url", "preferences", "is_default", "created_at", "last_seen"}).
+			AddRow("t1:p1", "u1", "Ada", "", []byte(`{}`), true, now, nil))
+	// The outbox columns must be those events.load scans.
+	mock.ExpectQuery(`SELECT id, event_type, player_id, tenant_id, game_id, payload, created_at FROM checkpoint_outbox WHERE player_id = \$1 AND tenant_id = \$2`).
+		WithArgs("t1:p1", "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "player_id", "tenant_id", "game_id", "payload", "created_at"}).
+			AddRow(1, "checkpoint.created", "t1:p1", "t1", "bss", []byte(`{"id":7,"checkpoint_data":"level 1"}`), now).
+			AddRow(2, "checkpoint.updated", "t1:p1", "t1", "bss", []byte(`{"id":7,"checkpoint_data":"level 3"}`), now))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/me/export", nil).WithContext(playerContext("t1", "u1", "t1:p1"))
+	w := httptest.NewRecorder()
+	exportPlayerData(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []model.CheckpointEvent
+	for _, f := range zr.File {
+		if f.Name != "history.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if err := json.NewDecoder(rc).Decode(&history); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(history) != 2 || history[1].GameID != "bss" || history[1].Checkpoint.CheckpointData != "level 3" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestEraseUnknownPlayerIsNotFound(t *testing.T) {
+	fakeSession(t, "tok-a-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	expectAdminAudit(mock, "DELETE /api/admin/players/{playerID}/data", "tenant-a:nobody")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id <> $2)`)).
+		WithArgs("tenant-a:nobody", "tenant-a").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id = $2)`)).
+		WithArgs("tenant-a:nobody", "tenant-a").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	w := serve(http.MethodDelete, "/api/admin/players/tenant-a:nobody/data", "tok-a-admin", nil)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codePlayerNotFound) {
+		t.Fatalf("status = %d, body %s; want 404 %s", w.Code, w.Body, codePlayerNotFound)
+	}
+}
+
+func TestErasureRevokesPlayersAPIKeys(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM gameplay_checkpoints`).WillReturnRows(sqlmock.NewRows([]string{"id", "game_id"}))
+	mock.ExpectExec(`UPDATE checkpoint_outbox`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE webhook_deliveries`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM players`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET revoked_at = now() WHERE player_id = $1 AND tenant_id = $2 AND revoked_at IS NULL`)).
+		WithArgs("t1:p1", "t1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM idempotency_keys`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM erasure_requests`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO erasure_tombstones`).WillReturnRows(sqlmock.NewRows([]string{"erased_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	var report ErasureReport
+	err := withTx(playerContext("t1", "u1", "t1:p1"), func(tx *sql.Tx) error {
+		var err error
+		report, err = erasePlayer(playerContext("t1", "u1", "t1:p1"), tx, "t1:p1", erasureByPlayer, "")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.APIKeysRevoked != 2 {
+		t.Errorf("api_keys_revoked = %d, want 2", report.APIKeysRevoked)
+	}
+}
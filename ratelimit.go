@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Rate limit headers, as in the IETF RateLimit header fields draft.
+const (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+	retryAfterHeader         = "Retry-After"
+)
+
+// rateLimitStats counts rejected requests per limiter and store failures,
+// published with expvar as "rate_limit".
+var rateLimitStats = expvar.NewMap("rate_limit")
+
+// rateLimit is a token bucket: it holds up to Burst requests and refills at
+// Rate requests per second.
+type rateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// rateLimitPolicy are the limits for each caller of a route: per client IP,
+// checked before authentication, and per player, checked after.
+type rateLimitPolicy struct {
+	IP     rateLimit `json:"ip"`
+	Player rateLimit `json:"player"`
+}
+
+// rateLimitConfig is the default policy plus overrides for routes, keyed by
+// method and route template, e.g. "PUT /api/gamecheckpoints/{id}". A route
+// with its own limit has its own buckets; every other route shares the
+// default ones. Limits left zero in an override fall back to the default.
+type rateLimitConfig struct {
+	rateLimitPolicy
+	Routes map[string]rateLimitPolicy `json:"routes"`
+	// Store keeps the buckets: "memory", in each process, or "postgres",
+	// shared by every instance (RATE_LIMIT_STORE).
+	Store string `json:"store"`
+	// TrustForwardedFor limits by the last X-Forwarded-For address, the one
+	// a proxy in front appended, instead of the peer's (TRUST_FORWARDED_FOR).
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+}
+
+// defaultRateLimits are the built-in limits. Checkpoint updates, which
+// clients autosave with, get a tighter limit so a client stuck in a loop is
+// stopped quickly.
+var defaultRateLimits = rateLimitConfig{
+	rateLimitPolicy: rateLimitPolicy{
+		IP:     rateLimit{Rate: 50, Burst: 100},
+		Player: rateLimit{Rate: 10, Burst: 30},
+	},
+	Store: "memory",
+	Routes: map[string]rateLimitPolicy{
+		"PUT /api/gamecheckpoints/{id}":            {Player: rateLimit{Rate: 2, Burst: 10}},
+		"PUT /api/games/{game}/checkpoints/{id}":   {Player: rateLimit{Rate: 2, Burst: 10}},
+		"POST /api/gamecheckpoints:batch":          {Player: rateLimit{Rate: 1, Burst: 5}},
+		"POST /api/games/{game}/checkpoints:batch": {Player: rateLimit{Rate: 1, Burst: 5}},
+	},
+}
+
+// parseRateLimitsEnv applies RATE_LIMITS, a JSON object in the shape of
+// rateLimitConfig, e.g. {"player": {"rate": 5, "burst": 20}, "routes":
+// {"PUT /api/gamecheckpoints/{id}": {"player": {"rate": 1, "burst": 5}}}},
+// over c. Settings it leaves out keep their values; its routes replace c's.
+func parseRateLimitsEnv(c *rateLimitConfig) error {
+	s := os.Getenv("RATE_LIMITS")
+	if s == "" {
+		return nil
+	}
+	v := *c
+	v.Routes = nil
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("RATE_LIMITS: not a valid rate limit configuration: %w", err)
+	}
+	if v.Routes == nil {
+		v.Routes = c.Routes
+	}
+	*c = v
+	return nil
+}
+
+// validate reports every invalid setting, named as in the configuration file.
+func (c rateLimitConfig) validate() []error {
+	var errs []error
+	if c.Store != "memory" && c.Store != "postgres" {
+		errs = append(errs, fmt.Errorf("rate_limits.store: %q must be memory or postgres", c.Store))
+	}
+	if err := c.IP.validate("rate_limits.ip"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Player.validate("rate_limits.player"); err != nil {
+		errs = append(errs, err)
+	}
+	for _, route := range slices.Sorted(maps.Keys(c.Routes)) {
+		p := c.Routes[route]
+		if fields := strings.Fields(route); len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			errs = append(errs, fmt.Errorf("rate_limits.routes: %q is not a method and route template", route))
+			continue
+		}
+		for _, l := range []struct {
+			kind  string
+			limit rateLimit
+		}{{"ip", p.IP}, {"player", p.Player}} {
+			if l.limit == (rateLimit{}) {
+				continue
+			}
+			if err := l.limit.validate(fmt.Sprintf("rate_limits.routes[%q].%s", route, l.kind)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (l rateLimit) validate(name string) error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return fmt.Errorf("%s: needs a positive rate and a burst of at least 1", name)
+	}
+	return nil
+}
+
+// limitFor returns the limit of kind ("ip" or "player") for route, and the
+// route itself if it has its own buckets or "" if it shares the default ones.
+func (c rateLimitConfig) limitFor(kind, route string) (rateLimit, string) {
+	def, override := c.IP, c.Routes[route].IP
+	if kind == "player" {
+		def, override = c.Player, c.Routes[route].Player
+	}
+	if override != (rateLimit{}) {
+		return override, route
+	}
+	return def, ""
+}
+
+// rateLimitResult is the outcome of taking a request from a bucket.
+type rateLimitResult struct {
+	Allowed bool
+	// Tokens left in the bucket afterwards.
+	Tokens float64
+}
+
+// rateLimitStore keeps token buckets. The in-process store limits each
+// instance separately; a shared store such as postgresRateLimitStore makes
+// the limits hold across instances.
+type rateLimitStore interface {
+	take(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error)
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, limit rateLimit) float64 {
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// memoryRateLimitStore keeps buckets in this process. Full buckets are
+// dropped every few minutes, since a missing bucket is full.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   rateLimit
+}
+
+// rateLimitSweepInterval is how often memoryRateLimitStore drops full buckets.
+const rateLimitSweepInterval = 5 * time.Minute
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (s *memoryRateLimitStore) take(_ context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range s.buckets {
+			if refill(b.tokens, now.Sub(b.updated), b.limit) >= float64(b.limit.Burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	b.limit = limit
+	if b.tokens < 1 {
+		return rateLimitResult{Allowed: false, Tokens: b.tokens}, nil
+	}
+	b.tokens--
+	return rateLimitResult{Allowed: true, Tokens: b.tokens}, nil
+}
+
+// postgresRateLimitStore keeps buckets in the rate_limit_buckets table, so
+// every instance draws from the same ones. Each take is a single upsert.
+type postgresRateLimitStore struct {
+	db *sql.DB
+}
+
+func (s postgresRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+	const tokens = `LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8)`
+	query := `INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at) VALUES ($1, $2::float8 - 1, true, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN ` + tokens + ` >= 1 THEN ` + tokens + ` - 1 ELSE ` + tokens + ` END,
+			allowed = ` + tokens + ` >= 1,
+			updated_at = now()
+		RETURNING tokens, allowed`
+	var res rateLimitResult
+	err := s.db.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&res.Tokens, &res.Allowed)
+	return res, err
+}
+
+// pruneRateLimitBuckets deletes shared buckets unused for an hour, long
+// enough for any of them to have refilled, until ctx is cancelled.
+func pruneRateLimitBuckets(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < now() - interval '1 hour'`); err != nil {
+				slog.Error("pruning rate limit buckets", "error", err)
+			}
+		}
+	}
+}
+
+// localRateLimits is the in-process store. It is also the fallback when the
+// shared store fails, so an outage of the shared store loosens the limits to
+// per instance rather than turning them off.
+var localRateLimits = newMemoryRateLimitStore()
+
+// rateLimitBuckets is the store in use, chosen by config.RateLimits.Store.
+var rateLimitBuckets rateLimitStore = localRateLimits
+
+// newRateLimitStore returns the store named by store, which has been
+// validated: "memory" or "postgres".
+func newRateLimitStore(store string, conn *sql.DB) rateLimitStore {
+	if store == "postgres" {
+		return postgresRateLimitStore{db: conn}
+	}
+	return localRateLimits
+}
+
+// ipRateLimitHandler limits requests per client IP, then serves them with
+// router. It wraps the router rather than running as its middleware so that
+// requests matching no route, which get a 404 or 405, are limited too. It
+// runs before authentication, so floods of bad tokens never reach Descope.
+func ipRateLimitHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// match.Route stays nil for paths no route matches.
+		var match mux.RouteMatch
+		router.Match(r, &match)
+		if limitRequest(w, r, match.Route, "ip", clientIP(r)) {
+			router.ServeHTTP(w, r)
+		}
+	})
+}
+
+// playerRateLimitMiddleware limits requests per player, or per API key for
+// keys that act as no player. It must run after sessionValidationMiddleware
+// and impersonationMiddleware; impersonated requests count against the
+// player.
+func playerRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := r.Context().Value(contextKeyPlayerID).(string)
+		if id == "" {
+			id, _ = r.Context().Value(contextKeyUserID).(string)
+		}
+		if id == "" || limitRequest(w, r, mux.CurrentRoute(r), "player", id) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// limitRequest takes r, which matched matched or no route if it is nil, from
+// the caller's bucket and sets the RateLimit headers. If the bucket is empty
+// it writes a 429 and returns false.
+func limitRequest(w http.ResponseWriter, r *http.Request, matched *mux.Route, kind, caller string) bool {
+	route := r.Method + " " + r.URL.Path
+	if matched != nil {
+		if tmpl, err := matched.GetPathTemplate(); err == nil {
+			route = r.Method + " " + tmpl
+		}
+	}
+	limit, bucketRoute := config.RateLimits.limitFor(kind, route)
+	key := kind + ":" + caller
+	if bucketRoute != "" {
+		key += ":" + bucketRoute
+	}
+
+	res, err := rateLimitBuckets.take(r.Context(), key, limit)
+	if err != nil {
+		rateLimitStats.Add("store_errors", 1)
+		slog.WarnContext(r.Context(), "rate limit store failed, limiting this instance only", "error", err)
+		res, _ = localRateLimits.take(r.Context(), key, limit)
+	}
+
+	// Reset is when the bucket is full again.
+	reset := math.Ceil((float64(limit.Burst) - res.Tokens) / limit.Rate)
+	w.Header().Set(rateLimitLimitHeader, strconv.Itoa(limit.Burst))
+	w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(int(math.Max(0, math.Floor(res.Tokens)))))
+	w.Header().Set(rateLimitResetHeader, strconv.Itoa(int(reset)))
+	if res.Allowed {
+		return true
+	}
+
+	rateLimitStats.Add("limited_"+kind, 1)
+	retryAfter := math.Max(1, math.Ceil((1-res.Tokens)/limit.Rate))
+	w.Header().Set(retryAfterHeader, strconv.Itoa(int(retryAfter)))
+	writeError(w, r, &apiError{Status: http.StatusTooManyRequests, Code: codeRateLimited, Detail: "Too many requests; retry after " + strconv.Itoa(int(retryAfter)) + "s"})
+	return false
+}
+
+// clientIP is the address r came from. Behind a proxy that appends to
+// X-Forwarded-For, set config.RateLimits.TrustForwardedFor to use the last
+// address in it instead, the one the proxy saw; earlier entries can be forged.
+func clientIP(r *http.Request) string {
+	if config.RateLimits.TrustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Paths no route matches are limited per IP like any other, so scanning for
+// them can't bypass the limit.
+func TestIPLimitCoversUnmatchedPaths(t *testing.T) {
+	prev := config
+	t.Cleanup(func() { config = prev })
+	config.RateLimits.IP = rateLimit{Rate: 0.001, Burst: 1}
+
+	h := ipRateLimitHandler(newRouter())
+	for i, want := range []int{http.StatusNotFound, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/no-such-page", nil)
+		r.RemoteAddr = "192.0.2.48:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/descope/go-sdk/descope"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// sessionRevocationChannel is the PostgreSQL NOTIFY channel that tells
+	// every instance to drop a user's cached sessions.
+	sessionRevocationChannel = "session_revocations"
+	// playerRevocationChannel tells every instance to forget a deleted
+	// player resolved for cached sessions.
+	playerRevocationChannel = "player_revocations"
+)
+
+// sessionCacheStats are the cache's counters, published with expvar as
+// "session_cache" and served by GET /api/admin/sessions/cache.
+var sessionCacheStats = expvar.NewMap("session_cache")
+
+// sessionCache remembers session tokens Descope has validated, so repeated
+// requests with the same token (autosaves) skip the validation call, and the
+// players resolved for them, so they skip provisioning too. Entries
+// are keyed by a hash of the token, expire after the cache's TTL or at the
+// token's own exp, whichever is first, and the least recently used entry is
+// evicted when the cache is full.
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List // of *sessionEntry, most recently used first
+}
+
+type sessionEntry struct {
+	hash    string
+	token   *descope.Token
+	expires time.Time
+	// players are the player IDs resolved for the session, keyed by
+	// playerCacheKey.
+	players map[string]string
+}
+
+// sessions is replaced at startup with a cache sized by the configuration.
+var sessions = newSessionCache(defaultConfig.SessionCache)
+
+// newSessionCache returns a cache holding up to s.Size sessions for s.TTL. A
+// zero TTL disables it.
+func newSessionCache(s sessionCacheSettings) *sessionCache {
+	c := &sessionCache{ttl: time.Duration(s.TTL), max: s.Size, entries: make(map[string]*list.Element), lru: list.New()}
+	for _, counter := range []string{"hits", "misses", "evictions", "revocations"} {
+		sessionCacheStats.Add(counter, 0)
+	}
+	sessionCacheStats.Set("entries", expvar.Func(func() any {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.lru.Len()
+	}))
+	return c
+}
+
+func (c *sessionCache) enabled() bool {
+	return c.ttl > 0
+}
+
+// get returns the validated token for sessionToken if it is cached and not
+// yet expired.
+func (c *sessionCache) get(sessionToken string) (*descope.Token, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	hash := hashToken(sessionToken)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[hash]
+	if !ok {
+		sessionCacheStats.Add("misses", 1)
+		return nil, false
+	}
+	entry := el.Value.(*sessionEntry)
+	if !time.Now().Before(entry.expires) {
+		c.remove(el)
+		sessionCacheStats.Add("misses", 1)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	sessionCacheStats.Add("hits", 1)
+	return entry.token, true
+}
+
+// put caches token, which Descope has just validated for sessionToken.
+func (c *sessionCache) put(sessionToken string, token *descope.Token) {
+	if !c.enabled() {
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	if token.Expiration > 0 {
+		if exp := time.Unix(token.Expiration, 0); exp.Before(expires) {
+			expires = exp
+		}
+	}
+	if !time.Now().Before(expires) {
+		return
+	}
+
+	hash := hashToken(sessionToken)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[hash]; ok {
+		c.remove(el)
+	}
+	c.entries[hash] = c.lru.PushFront(&sessionEntry{hash: hash, token: token, expires: expires})
+	for c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+		sessionCacheStats.Add("evictions", 1)
+	}
+}
+
+// player returns the player ID cached for sessionToken under key.
+func (c *sessionCache) player(sessionToken, key string) (string, bool) {
+	if !c.enabled() {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[hashToken(sessionToken)]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*sessionEntry)
+	if !time.Now().Before(entry.expires) {
+		return "", false
+	}
+	playerID, ok := entry.players[key]
+	return playerID, ok
+}
+
+// putPlayer caches playerID for sessionToken under key, if the session itself
+// is cached.
+func (c *sessionCache) putPlayer(sessionToken, key, playerID string) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[hashToken(sessionToken)]
+	if !ok {
+		return
+	}
+	entry := el.Value.(*sessionEntry)
+	if entry.players == nil {
+		entry.players = make(map[string]string)
+	}
+	entry.players[key] = playerID
+}
+
+// dropPlayer forgets playerID wherever it is cached on this instance.
+func (c *sessionCache) dropPlayer(playerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		players := el.Value.(*sessionEntry).players
+		for key, id := range players {
+			if id == playerID {
+				delete(players, key)
+			}
+		}
+	}
+}
+
+// forgetPlayer forgets playerID on every instance, so sessions that resolved
+// to it resolve their player again. Call it after deleting the player.
+func (c *sessionCache) forgetPlayer(ctx context.Context, playerID string) error {
+	c.dropPlayer(playerID)
+	_, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, playerRevocationChannel, playerID)
+	return err
+}
+
+// remove drops el; the caller holds c.mu.
+func (c *sessionCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*sessionEntry).hash)
+	c.lru.Remove(el)
+}
+
+// dropUser forgets every cached session of userID on this instance, closes
+// the WebSockets they opened here and returns how many sessions there were.
+func (c *sessionCache) dropUser(userID string) int {
+	hub.closeUser(userID, "session revoked")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*sessionEntry).token.ID == userID {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	sessionCacheStats.Add("revocations", int64(n))
+	return n
+}
+
+// revokeUser forgets userID's cached sessions on every instance, so their
+// next request is validated with Descope again, and closes their WebSockets.
+// Call it after signing a user out or changing their roles in Descope.
+func (c *sessionCache) revokeUser(ctx context.Context, userID string) error {
+	c.dropUser(userID)
+	_, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, sessionRevocationChannel, userID)
+	return err
+}
+
+// run drops the sessions and players revoked by other instances until ctx is
+// cancelled. It runs even with the cache off, since revocations also close
+// WebSockets.
+func (c *sessionCache) run(ctx context.Context, dsn string) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("session revocation listener", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+	for _, channel := range []string{sessionRevocationChannel, playerRevocationChannel} {
+		if err := listener.Listen(channel); err != nil {
+			slog.Error("session revocations from other instances are unavailable", "error", err)
+			return
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// A nil notification follows a reconnect, after which revocations
+			// may have been missed; start over.
+			if n == nil {
+				c.clear()
+				continue
+			}
+			if n.Channel == playerRevocationChannel {
+				c.dropPlayer(n.Extra)
+			} else {
+				c.dropUser(n.Extra)
+			}
+		}
+	}
+}
+
+// clear forgets every cached session on this instance.
+func (c *sessionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// revokeUserSessions handles DELETE /api/admin/sessions/{userID}, dropping
+// the user's cached sessions on every instance. User IDs span tenants, so it
+// is for project-wide admins only (see projectPermissions).
+func revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userID"]
+	if err := sessions.revokeUser(r.Context(), userID); err != nil {
+		writeError(w, r, errInternal("revoking sessions", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Cached sessions revoked"})
+}
+
+// getSessionCacheStats handles GET /api/admin/sessions/cache with this
+// instance's cache settings and counters, which cover every tenant.
+func getSessionCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]any{
+		"enabled":     sessions.enabled(),
+		"ttl_seconds": sessions.ttl.Seconds(),
+		"max_entries": sessions.max,
+	}
+	sessionCacheStats.Do(func(kv expvar.KeyValue) {
+		stats[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+	writeJSON(w, r, http.StatusOK, stats)
+}
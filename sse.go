@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"studentbackendgosql/model"
+)
+
+const (
+	// sseHeartbeat keeps idle streams open through proxies.
+	sseHeartbeat = 15 * time.Second
+	// sseSubscriberBuffer is how many events may queue for a slow stream
+	// before it is closed; the client resumes from the buffer on reconnect.
+	sseSubscriberBuffer = 256
+)
+
+// eventLog keeps the most recent checkpoint events in a ring buffer and feeds
+// them to live SSE streams.
+type eventLog struct {
+	mu     sync.Mutex
+	buf    []model.CheckpointEvent
+	start  int // index of the oldest event in buf
+	count  int
+	nextCh map[chan model.CheckpointEvent]struct{}
+}
+
+// recentEvents is replaced at startup with a log sized by the configuration.
+var recentEvents = newEventLog(defaultConfig.Events.BufferSize)
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{
+		buf:    make([]model.CheckpointEvent, size),
+		nextCh: make(map[chan model.CheckpointEvent]struct{}),
+	}
+}
+
+// Publish implements eventSink.
+func (l *eventLog) Publish(_ context.Context, ev model.CheckpointEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count < len(l.buf) {
+		l.buf[(l.start+l.count)%len(l.buf)] = ev
+		l.count++
+	} else {
+		l.buf[l.start] = ev
+		l.start = (l.start + 1) % len(l.buf)
+	}
+
+	for ch := range l.nextCh {
+		select {
+		case ch <- ev:
+		default:
+			delete(l.nextCh, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// subscribe returns the buffered events after lastID and a channel of the
+// events that follow, with nothing lost in between. complete is false when
+// events after lastID have already been evicted from the buffer or lastID is
+// unknown.
+func (l *eventLog) subscribe(lastID int64) (replay []model.CheckpointEvent, complete bool, ch chan model.CheckpointEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	complete = true
+	if lastID > 0 && (l.count == 0 || l.buf[(l.start+l.count-1)%len(l.buf)].ID < lastID) {
+		// lastID is newer than anything buffered, e.g. from before a restart
+		// that emptied the buffer, so replay everything.
+		complete = false
+		lastID = 0
+	}
+	for i := 0; i < l.count; i++ {
+		ev := l.buf[(l.start+i)%len(l.buf)]
+		if i == 0 && lastID > 0 && ev.ID > lastID+1 {
+			complete = false
+		}
+		if ev.ID > lastID {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch = make(chan model.CheckpointEvent, sseSubscriberBuffer)
+	l.nextCh[ch] = struct{}{}
+	return replay, complete, ch
+}
+
+func (l *eventLog) unsubscribe(ch chan model.CheckpointEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.nextCh[ch]; ok {
+		delete(l.nextCh, ch)
+		close(ch)
+	}
+}
+
+// adminEventsStream streams the checkpoint lifecycle events of the admin's
+// tenant to the admin dashboard as Server-Sent Events. A reconnecting EventSource sends Last-Event-ID and
+// receives the buffered events it missed; if some were already evicted, a
+// "resync" event tells the dashboard to reload instead.
+func adminEventsStream(w http.ResponseWriter, r *http.Request) {
+	lastID, err := lastEventID(r)
+	if err != nil {
+		writeError(w, r, errValidation("Last-Event-ID must be an event ID"))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	replay, complete, ch := recentEvents.subscribe(lastID)
+	defer recentEvents.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	tenantID := tenantFromContext(r.Context())
+	for _, ev := range replay {
+		if ev.TenantID != tenantID {
+			continue
+		}
+		if err := writeSSEEvent(w, ev); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		slog.WarnContext(r.Context(), "event stream cannot be flushed", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes.
+				return
+			}
+			if ev.TenantID != tenantID {
+				continue
+			}
+			if err := writeSSEEvent(w, ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// lastEventID reads the resume point from the Last-Event-ID header, or the
+// last_event_id query parameter for clients that can't set headers.
+func lastEventID(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+}
+
+func writeSSEEvent(w http.ResponseWriter, ev model.CheckpointEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
+
+// acceptsEventStream reports whether r comes from an EventSource.
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/descope/go-sdk/descope"
+)
+
+// tenantIDHeader selects which of the session's Descope tenants a request acts
+// in, for users who belong to more than one.
+const tenantIDHeader = "X-Tenant-ID"
+
+const contextKeyTenantID contextKey = "tenantID"
+
+// resolveTenant returns the tenant a request acts in: selected if the token
+// holds it, the token's only tenant otherwise, or the empty tenant for users
+// outside any tenant (single-tenant deployments).
+func resolveTenant(token *descope.Token, selected string) (string, error) {
+	tenants := token.GetTenants()
+	if selected != "" {
+		if !slices.Contains(tenants, selected) {
+			return "", errForbidden(codeForbidden, tenantIDHeader+" is not one of your tenants")
+		}
+		return selected, nil
+	}
+	switch len(tenants) {
+	case 0:
+		return "", nil
+	case 1:
+		return tenants[0], nil
+	}
+	return "", errValidation("You belong to several tenants; choose one with the " + tenantIDHeader + " header")
+}
+
+// tenantRoles lists the configured roles token holds in tenant: its
+// project-wide roles plus, inside a tenant, the roles granted there.
+// projectRoles are the project-wide ones.
+func tenantRoles(ctx context.Context, token *descope.Token, tenant string) (roles, projectRoles []string) {
+	for _, role := range configuredRoles() {
+		if descopeClient.Auth.ValidateRoles(ctx, token, []string{role}) {
+			roles = append(roles, role)
+			projectRoles = append(projectRoles, role)
+		} else if tenant != "" && descopeClient.Auth.ValidateTenantRoles(ctx, token, tenant, []string{role}) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, projectRoles
+}
+
+// withTenant returns ctx acting in tenant. Requests get theirs from the
+// session middleware; maintenance commands set it themselves.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKeyTenantID, tenant)
+}
+
+// tenantFromContext is the tenant every query of the request is confined to.
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(contextKeyTenantID).(string)
+	return tenant
+}
+
+// checkPlayerTenant rejects assigning a checkpoint to a player of another
+// tenant. Player IDs without a profile (saves from before profiles existed)
+// are allowed.
+func checkPlayerTenant(ctx context.Context, tx *sql.Tx, playerID string) *apiError {
+	if playerID == "" {
+		return nil
+	}
+	var foreign bool
+	query := `SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id <> $2)`
+	if err := tx.QueryRowContext(ctx, query, playerID, tenantFromContext(ctx)).Scan(&foreign); err != nil {
+		return errInternal("looking up player tenant", err)
+	}
+	if foreign {
+		return errValidation("player_id is not a player in this tenant")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"studentbackendgosql/model"
+)
+
+// A checkpoint of tenant B is out of reach of tenant A's players and admins:
+// every query is confined to tenant A, so it is simply not found.
+func TestTenantCannotReachOtherTenantsCheckpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		roles []string
+	}{
+		{"player", nil},
+		{"admin", []string{adminRole}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			admin := tc.roles != nil
+
+			t.Run("read", func(t *testing.T) {
+				fakeSession(t, "tok-a-"+tc.name, "user-a", "tenant-a", tc.roles...)
+				mock := mockDB(t)
+				playerID := expectDefaultPlayer(mock, "tenant-a", "user-a")
+				if admin {
+					mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3`)).
+						WithArgs(99, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				} else {
+					mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2 AND tenant_id = $3 AND game_id = $4`)).
+						WithArgs(99, playerID, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				}
+				expectNotFound(t, serve(http.MethodGet, "/api/gamecheckpoints/99", "tok-a-"+tc.name, nil))
+			})
+
+			t.Run("update", func(t *testing.T) {
+				fakeSession(t, "tok-a-"+tc.name, "user-a", "tenant-a", tc.roles...)
+				mock := mockDB(t)
+				playerID := expectDefaultPlayer(mock, "tenant-a", "user-a")
+				mock.ExpectBegin()
+				if admin {
+					mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3 FOR UPDATE`)).
+						WithArgs(99, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				} else {
+					mock.ExpectQuery(regexp.QuoteMeta(`UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2 WHERE id = $3 AND player_id = $4 AND tenant_id = $5 AND game_id = $6`)).
+						WithArgs("ada", "level 9", 99, playerID, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				}
+				mock.ExpectRollback()
+				body := strings.NewReader(`{"user_name":"ada","checkpoint_data":"level 9"}`)
+				expectNotFound(t, serve(http.MethodPut, "/api/gamecheckpoints/99", "tok-a-"+tc.name, body))
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				fakeSession(t, "tok-a-"+tc.name, "user-a", "tenant-a", tc.roles...)
+				mock := mockDB(t)
+				playerID := expectDefaultPlayer(mock, "tenant-a", "user-a")
+				mock.ExpectBegin()
+				if admin {
+					mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3 FOR UPDATE`)).
+						WithArgs(99, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				} else {
+					mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2 AND tenant_id = $3 AND game_id = $4`)).
+						WithArgs(99, playerID, "tenant-a", model.DefaultGameID).
+						WillReturnRows(sqlmock.NewRows(nil))
+				}
+				mock.ExpectRollback()
+				expectNotFound(t, serve(http.MethodDelete, "/api/gamecheckpoints/99", "tok-a-"+tc.name, nil))
+			})
+		})
+	}
+}
+
+// A tenant A admin can't erase a player of tenant B: the erasure is refused
+// before anything is deleted.
+func TestTenantAdminCannotEraseOtherTenantsPlayer(t *testing.T) {
+	fakeSession(t, "tok-a-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	expectAdminAudit(mock, "DELETE /api/admin/players/{playerID}/data", "tenant-b:user-b")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id <> $2)`)).
+		WithArgs("tenant-b:user-b", "tenant-a").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	w := serve(http.MethodDelete, "/api/admin/players/tenant-b:user-b/data", "tok-a-admin", nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeValidationFailed) {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func expectNotFound(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeCheckpointNotFound) {
+		t.Fatalf("status = %d, body %s; want 404 %s", w.Code, w.Body, codeCheckpointNotFound)
+	}
+}
+
+// Games are shared by every tenant, so an admin of one tenant can't manage
+// them; a project-wide admin can.
+func TestGamesNeedProjectWideRole(t *testing.T) {
+	fakeSession(t, "tok-tenant-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	expectAdminAudit(mock, "POST /api/admin/games", "")
+	w := serve(http.MethodPost, "/api/admin/games", "tok-tenant-admin", strings.NewReader(`{"id":"g2","name":"G2"}`))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("tenant admin creating a game: status %d, want 403: %s", w.Code, w.Body)
+	}
+
+	fakeProjectSession(t, "tok-project-admin", "user-p", adminRole)
+	expectDefaultPlayer(mock, "", "user-p")
+	expectAdminAudit(mock, "GET /api/admin/games", "")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM games ORDER BY id`)).WillReturnRows(sqlmock.NewRows(nil))
+	if w := serve(http.MethodGet, "/api/admin/games", "tok-project-admin", nil); w.Code != http.StatusOK {
+		t.Fatalf("project admin listing games: status %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// The session cache holds every tenant's sessions, so only a project-wide
+// admin may inspect it or revoke a user's sessions.
+func TestSessionCacheNeedsProjectWideRole(t *testing.T) {
+	fakeSession(t, "tok-tenant-admin", "user-a", "tenant-a", adminRole)
+	fakeSession(t, "tok-b", "user-b", "tenant-b")
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	for _, req := range []struct{ method, path, route string }{
+		{http.MethodGet, "/api/admin/sessions/cache", "/api/admin/sessions/cache"},
+		{http.MethodDelete, "/api/admin/sessions/user-b", "/api/admin/sessions/{userID}"},
+	} {
+		expectAdminAudit(mock, req.method+" "+req.route, "")
+		if w := serve(req.method, req.path, "tok-tenant-admin", nil); w.Code != http.StatusForbidden {
+			t.Errorf("tenant admin %s %s: status %d, want 403", req.method, req.path, w.Code)
+		}
+	}
+	if _, ok := sessions.get("tok-b"); !ok {
+		t.Error("tenant admin revoked another tenant's session")
+	}
+
+	fakeProjectSession(t, "tok-project-admin", "user-p", adminRole)
+	expectDefaultPlayer(mock, "", "user-p")
+	expectAdminAudit(mock, "GET /api/admin/sessions/cache", "")
+	if w := serve(http.MethodGet, "/api/admin/sessions/cache", "tok-project-admin", nil); w.Code != http.StatusOK {
+		t.Fatalf("project admin reading cache stats: status %d, want 200: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// certPollInterval is how often the certificate files are checked for
+// changes.
+const certPollInterval = 30 * time.Second
+
+// certReloader serves the key pair in certFile and keyFile, loading it again
+// when either file changes, so renewed certificates are picked up without a
+// restart.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	// modTimes of the files when cert was loaded.
+	certMod, keyMod time.Time
+}
+
+// newCertReloader loads the key pair, failing if it is unusable.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the key pair if either file changed since the last load. On
+// error the certificate in use is kept.
+func (c *certReloader) reload() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+	if certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	c.cert.Store(&cert)
+	c.certMod, c.keyMod = certInfo.ModTime(), keyInfo.ModTime()
+	return nil
+}
+
+// run reloads the key pair when it changes until ctx is cancelled. A file
+// caught halfway through being rewritten fails to load and is tried again on
+// the next poll.
+func (c *certReloader) run(ctx context.Context) {
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := c.cert.Load()
+			if err := c.reload(); err != nil {
+				slog.Error("reloading TLS certificate", "error", err)
+			} else if c.cert.Load() != before {
+				slog.Info("reloaded TLS certificate", "cert_file", c.certFile)
+			}
+		}
+	}
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// newTLSConfig builds the server's TLS configuration from s. With a client CA,
+// clients may present a certificate signed by it; adminClientCertMiddleware
+// then requires one on admin routes, and clientCertGrantsMiddleware for using
+// admin permissions anywhere else. Players never need one.
+func newTLSConfig(s tlsSettings, certs *certReloader) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if s.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("client CA file holds no PEM certificates")
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// newServer returns the server for handler, with the timeouts in h. With a
+// tlsConfig it speaks HTTP/2 as well as HTTP/1.1; WebSocket clients fall back
+// to HTTP/1.1 for the upgrade.
+func newServer(addr string, handler http.Handler, tlsConfig *tls.Config, h httpSettings) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		Protocols:         new(http.Protocols),
+		ReadHeaderTimeout: time.Duration(h.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(h.ReadTimeout),
+		IdleTimeout:       time.Duration(h.IdleTimeout),
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	return srv
+}
+
+// hstsMiddleware tells browsers to use HTTPS for maxAge seconds, on responses
+// sent over TLS.
+func hstsMiddleware(maxAge int, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(maxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && maxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasAdminClientCert reports whether r may exercise admin permissions: it
+// carries a verified client certificate, or no client CA is configured.
+func hasAdminClientCert(r *http.Request) bool {
+	return config.TLS.ClientCAFile == "" || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+}
+
+// adminClientCertMiddleware requires a verified client certificate when a
+// client CA is configured; without one it lets every request through.
+func adminClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasAdminClientCert(r) {
+			writeError(w, r, errForbidden(codeForbidden, "Admin routes require a client certificate"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCertGrantsMiddleware extends the client certificate requirement
+// beyond /api/admin: a request without one, when a client CA is configured,
+// keeps its roles but none of their permissions, so it acts as a plain player
+// on the checkpoint routes, and impersonation is refused. It must run after
+// sessionValidationMiddleware.
+func clientCertGrantsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := grantsFromContext(r.Context())
+		if hasAdminClientCert(r) || len(g.Permissions) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get(impersonatePlayerHeader) != "" {
+			writeError(w, r, errForbidden(codeForbidden, "Impersonation requires a client certificate"))
+			return
+		}
+		g.Permissions = map[string]bool{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyGrants, g)))
+	})
+}
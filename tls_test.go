@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"studentbackendgosql/model"
+)
+
+// With a client CA configured, an admin without a client certificate acts as a
+// plain player outside /api/admin too, and can't impersonate.
+func TestAdminPermissionsNeedClientCert(t *testing.T) {
+	prev := config.TLS.ClientCAFile
+	config.TLS.ClientCAFile = "client-ca.pem"
+	t.Cleanup(func() { config.TLS.ClientCAFile = prev })
+
+	fakeSession(t, "tok-admin", "user-a", "tenant-a", adminRole)
+	mock := mockDB(t)
+	playerID := expectDefaultPlayer(mock, "tenant-a", "user-a")
+	get := func(cert bool, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/gamecheckpoints/99", nil)
+		r.Header.Set("Authorization", "Bearer tok-admin")
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		if cert {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+		}
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		return w
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND player_id = $2 AND tenant_id = $3 AND game_id = $4`)).
+		WithArgs(99, playerID, "tenant-a", model.DefaultGameID).
+		WillReturnRows(sqlmock.NewRows(nil))
+	if w := get(false); w.Code != http.StatusNotFound {
+		t.Errorf("without a certificate: status %d, want 404", w.Code)
+	}
+
+	if w := get(false, impersonatePlayerHeader, "tenant-a:user-b"); w.Code != http.StatusForbidden {
+		t.Errorf("impersonating without a certificate: status %d, want 403", w.Code)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM gameplay_checkpoints WHERE id = $1 AND tenant_id = $2 AND game_id = $3`)).
+		WithArgs(99, "tenant-a", model.DefaultGameID).
+		WillReturnRows(sqlmock.NewRows(nil))
+	if w := get(true); w.Code != http.StatusNotFound {
+		t.Errorf("with a certificate: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName is reported on every span unless OTEL_SERVICE_NAME overrides it.
+const serviceName = "bssbackendgo"
+
+// tracer is used for the spans this server creates itself (middleware,
+// handlers and JSON encoding). Router and SQL spans come from otelmux and otelsql.
+var tracer = otel.Tracer("studentbackendgosql")
+
+// initTracing installs the global tracer provider and the W3C trace context
+// propagator so a traceparent header sent by the game client continues its trace.
+//
+// OTEL_TRACES_EXPORTER picks the exporter:
+//   - "otlp":   OTLP over HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+//   - "stdout": pretty-printed spans on stdout, handy when no collector is running
+//   - "none" or unset: spans are created and propagated but not exported
+//
+// The returned function flushes and stops the provider; call it on shutdown.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	switch exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "", "none":
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("creating stdout exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q (want otlp, stdout or none)", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// openTracedDB opens a PostgreSQL handle whose statements each produce a span.
+// Callers must use the *Context query methods so the span joins the request trace.
+func openTracedDB(dsn string) (*sql.DB, error) {
+	return otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+}
+
+// traced wraps a route handler in a span named after it, so the handler's own
+// time is visible between the session validation and SQL spans.
+func traced(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), name)
+		defer span.End()
+		span.SetAttributes(attribute.StringSlice("bss.roles", grantsFromContext(r.Context()).Roles))
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// recordSpanError marks span as failed with err.
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"studentbackendgosql/model"
+)
+
+// Bulk transfer formats: one JSON checkpoint per line, or CSV with a header row.
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// maxImportErrors bounds the per-record errors kept in an import report.
+const maxImportErrors = 100
+
+// csvColumns is the header of exported CSV files. Imports accept the columns
+// in any order; only user_name and checkpoint_data are required.
+var csvColumns = []string{"id", "user_name", "checkpoint_data", "created_at", "last_edited_at", "player_id"}
+
+// exportFilter selects the checkpoints to export. Since and Until bound
+// last_edited_at; zero values leave that side open.
+type exportFilter struct {
+	PlayerID string
+	Since    time.Time
+	Until    time.Time
+}
+
+// importOptions controls how imported records are written. Without
+// PreserveIDs every record gets a new ID and OnConflict is unused.
+type importOptions struct {
+	PreserveIDs bool
+	OnConflict  string
+	DryRun      bool
+}
+
+// exportCheckpointsTo streams the checkpoints of ctx's tenant and game matching f to w
+// in the given format, in ID order, and returns how many were written.
+func exportCheckpointsTo(ctx context.Context, db *sql.DB, w io.Writer, format string, f exportFilter) (int, error) {
+	cw, err := newCheckpointWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `SELECT id, user_name, checkpoint_data, created_at, last_edited_at, COALESCE(player_id, '') FROM gameplay_checkpoints
+		WHERE ($1 = '' OR player_id = $1)
+			AND ($2::timestamptz IS NULL OR last_edited_at >= $2)
+			AND ($3::timestamptz IS NULL OR last_edited_at < $3)
+			AND tenant_id = $4 AND game_id = $5
+		ORDER BY id`
+	rows, err := db.QueryContext(ctx, query, f.PlayerID, nullTime(f.Since), nullTime(f.Until), tenantFromContext(ctx), gameIDFromContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.ID, &cp.Username, &cp.CheckpointData, &cp.CreatedAt, &cp.LastEditedAt, &cp.PlayerID); err != nil {
+			return n, err
+		}
+		if err := cw.Write(cp); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, cw.Flush()
+}
+
+// importCheckpointsFrom reads checkpoints from r into ctx's tenant and game in one
+// transaction, each record under its own savepoint so a bad record is
+// reported without aborting the rest. A dry run rolls the transaction back.
+// Every created or overwritten checkpoint gets an outbox event.
+func importCheckpointsFrom(ctx context.Context, db *sql.DB, r io.Reader, format string, opts importOptions) (model.ImportReport, error) {
+	report := model.ImportReport{DryRun: opts.DryRun}
+	if opts.OnConflict == "" {
+		opts.OnConflict = model.ConflictSkip
+	}
+	switch opts.OnConflict {
+	case model.ConflictSkip, model.ConflictOverwrite, model.ConflictRenumber:
+	default:
+		return report, errValidation(`on_conflict must be "skip", "overwrite" or "renumber"`)
+	}
+	cr, err := newCheckpointReader(r, format)
+	if err != nil {
+		return report, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	fail := func(line int, detail string) {
+		report.Failed++
+		if len(report.Errors) < maxImportErrors {
+			report.Errors = append(report.Errors, model.ImportError{Line: line, Detail: detail})
+		}
+	}
+
+	for {
+		cp, line, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		var recErr *recordError
+		if errors.As(err, &recErr) {
+			fail(line, recErr.Error())
+			continue
+		} else if err != nil {
+			return report, err
+		}
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT import_record`); err != nil {
+			return report, err
+		}
+		outcome, err := importCheckpoint(ctx, tx, cp, opts)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_record`); rbErr != nil {
+				return report, rbErr
+			}
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError {
+				fail(line, apiErr.Detail)
+			} else {
+				// The cause may describe the database, so it is only logged,
+				// under the request ID.
+				slog.ErrorContext(ctx, "importing checkpoint", "line", line, "error", err)
+				fail(line, "An internal error occurred")
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT import_record`); err != nil {
+			return report, err
+		}
+		switch outcome {
+		case importCreated:
+			report.Created++
+		case importOverwritten:
+			report.Overwritten++
+		case importRenumbered:
+			report.Renumbered++
+		case importSkipped:
+			report.Skipped++
+		}
+	}
+
+	if opts.PreserveIDs {
+		// Keep the ID sequence ahead of the IDs written explicitly.
+		query := `SELECT setval(pg_get_serial_sequence('gameplay_checkpoints', 'id'), GREATEST((SELECT MAX(id) FROM gameplay_checkpoints), 1))`
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return report, err
+		}
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+type importOutcome int
+
+const (
+	importCreated importOutcome = iota
+	importOverwritten
+	importRenumbered
+	importSkipped
+)
+
+// importCheckpoint writes one record inside tx. A preserved ID taken by
+// another tenant's or game's checkpoint is renumbered whatever the conflict
+// policy, so an import can never touch rows outside its tenant and game.
+// Records must fit the game's size limit and schema; quotas are not applied.
+// Every write is audited in tx, overwrites with the checkpoint they replaced.
+func importCheckpoint(ctx context.Context, tx *sql.Tx, cp Checkpoint, opts importOptions) (importOutcome, error) {
+	if err := checkPlayerTenant(ctx, tx, cp.PlayerID); err != nil {
+		return 0, err
+	}
+	if err := checkCheckpointForGame(ctx, tx, cp, false); err != nil {
+		return 0, err
+	}
+	if !opts.PreserveIDs || cp.ID == 0 {
+		return importCreated, insertImported(ctx, tx, cp, false)
+	}
+
+	var tenantID, gameID string
+	err := tx.QueryRowContext(ctx, `SELECT tenant_id, game_id FROM gameplay_checkpoints WHERE id = $1`, cp.ID).Scan(&tenantID, &gameID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return importCreated, insertImported(ctx, tx, cp, true)
+	} else if err != nil {
+		return 0, err
+	}
+	if tenantID != tenantFromContext(ctx) || gameID != gameIDFromContext(ctx) {
+		return importRenumbered, insertImported(ctx, tx, cp, false)
+	}
+
+	switch opts.OnConflict {
+	case model.ConflictOverwrite:
+		before, err := lockCheckpoint(ctx, tx, cp.ID)
+		if err != nil {
+			return 0, err
+		}
+		// last_edited_at is set by the update trigger.
+		query := `UPDATE gameplay_checkpoints SET user_name = $1, checkpoint_data = $2, player_id = NULLIF($3, ''), created_at = COALESCE($4, created_at)
+			WHERE id = $5 AND tenant_id = $6 AND game_id = $7 RETURNING created_at, last_edited_at`
+		if err := tx.QueryRowContext(ctx, query, cp.Username, cp.CheckpointData, cp.PlayerID, nullTime(cp.CreatedAt), cp.ID, tenantFromContext(ctx), gameIDFromContext(ctx)).Scan(&cp.CreatedAt, &cp.LastEditedAt); err != nil {
+			return 0, err
+		}
+		if err := writeOutboxEvent(ctx, tx, model.EventCheckpointUpdated, cp); err != nil {
+			return 0, err
+		}
+		return importOverwritten, recordAudit(ctx, tx, auditCheckpointUpdate, cp.ID, cp.PlayerID, before, &cp)
+	case model.ConflictRenumber:
+		return importRenumbered, insertImported(ctx, tx, cp, false)
+	}
+	return importSkipped, nil
+}
+
+// insertImported inserts cp, keeping its ID if keepID is set and its
+// timestamps when the record had them, and audits it.
+func insertImported(ctx context.Context, tx *sql.Tx, cp Checkpoint, keepID bool) error {
+	query := `INSERT INTO gameplay_checkpoints (id, user_name, checkpoint_data, player_id, created_at, last_edited_at, tenant_id, game_id)
+		VALUES (COALESCE($1, nextval(pg_get_serial_sequence('gameplay_checkpoints', 'id'))), $2, $3, NULLIF($4, ''), COALESCE($5, now()), COALESCE($6, now()), $7, $8)
+		RETURNING id, created_at, last_edited_at`
+	var id sql.NullInt64
+	if keepID {
+		id = sql.NullInt64{Int64: int64(cp.ID), Valid: true}
+	}
+	err := tx.QueryRowContext(ctx, query, id, cp.Username, cp.CheckpointData, cp.PlayerID, nullTime(cp.CreatedAt), nullTime(cp.LastEditedAt), tenantFromContext(ctx), gameIDFromContext(ctx)).
+		Scan(&cp.ID, &cp.CreatedAt, &cp.LastEditedAt)
+	if err != nil {
+		return err
+	}
+	if err := writeOutboxEvent(ctx, tx, model.EventCheckpointCreated, cp); err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, auditCheckpointCreate, cp.ID, cp.PlayerID, nil, &cp)
+}
+
+// nullTime maps the zero time to SQL NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// recordError is a malformed input record; the import skips it and carries on.
+type recordError struct{ msg string }
+
+func (e *recordError) Error() string { return e.msg }
+
+type checkpointWriter interface {
+	Write(cp Checkpoint) error
+	Flush() error
+}
+
+type checkpointReader interface {
+	// Next returns the next record and its line number, a *recordError for a
+	// malformed record, or io.EOF at the end.
+	Next() (Checkpoint, int, error)
+}
+
+func newCheckpointWriter(w io.Writer, format string) (checkpointWriter, error) {
+	switch format {
+	case formatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	}
+	return nil, errValidation(`format must be "ndjson" or "csv"`)
+}
+
+func newCheckpointReader(r io.Reader, format string) (checkpointReader, error) {
+	switch format {
+	case formatNDJSON:
+		return &ndjsonReader{r: bufio.NewReader(r)}, nil
+	case formatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, errValidation("CSV input has no header row")
+		}
+		cols := make(map[string]int, len(header))
+		for i, name := range header {
+			cols[name] = i
+		}
+		for _, required := range []string{"user_name", "checkpoint_data"} {
+			if _, ok := cols[required]; !ok {
+				return nil, errValidation("CSV header is missing the " + required + " column")
+			}
+		}
+		cr.FieldsPerRecord = len(header)
+		return &csvReader{r: cr, cols: cols}, nil
+	}
+	return nil, errValidation(`format must be "ndjson" or "csv"`)
+}
+
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(cp Checkpoint) error { return n.enc.Encode(cp) }
+func (n *ndjsonWriter) Flush() error              { return n.w.Flush() }
+
+type csvWriter struct{ w *csv.Writer }
+
+func (c *csvWriter) Write(cp Checkpoint) error {
+	return c.w.Write([]string{
+		strconv.Itoa(cp.ID),
+		cp.Username,
+		cp.CheckpointData,
+		cp.CreatedAt.Format(time.RFC3339Nano),
+		cp.LastEditedAt.Format(time.RFC3339Nano),
+		cp.PlayerID,
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (n *ndjsonReader) Next() (Checkpoint, int, error) {
+	for {
+		data, err := n.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return Checkpoint{}, n.line, err
+		}
+		n.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		var cp Checkpoint
+		if err := json.Unmarshal(data, &cp); err != nil {
+			return Checkpoint{}, n.line, &recordError{"invalid JSON: " + err.Error()}
+		}
+		return cp, n.line, nil
+	}
+}
+
+type csvReader struct {
+	r    *csv.Reader
+	cols map[string]int
+}
+
+func (c *csvReader) Next() (Checkpoint, int, error) {
+	rec, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Checkpoint{}, parseErr.Line, &recordError{parseErr.Error()}
+	} else if err != nil {
+		return Checkpoint{}, 0, err
+	}
+	line, _ := c.r.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := c.cols[name]; ok {
+			return rec[i]
+		}
+		return ""
+	}
+	cp := Checkpoint{
+		Username:       field("user_name"),
+		CheckpointData: field("checkpoint_data"),
+		PlayerID:       field("player_id"),
+	}
+	if s := field("id"); s != "" {
+		if cp.ID, err = strconv.Atoi(s); err != nil {
+			return Checkpoint{}, line, &recordError{"invalid id " + strconv.Quote(s)}
+		}
+	}
+	for name, t := range map[string]*time.Time{"created_at": &cp.CreatedAt, "last_edited_at": &cp.LastEditedAt} {
+		if s := field(name); s != "" {
+			if *t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return Checkpoint{}, line, &recordError{"invalid " + name + " " + strconv.Quote(s)}
+			}
+		}
+	}
+	return cp, line, nil
+}
+
+// parseTimeBound reads an export date filter given as RFC 3339 or YYYY-MM-DD.
+func parseTimeBound(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// exportCheckpoints handles GET /api/admin/checkpoints/export, streaming the
+// matching checkpoints of ?game= (default bss) as NDJSON (the default) or CSV.
+func exportCheckpoints(w http.ResponseWriter, r *http.Request) {
+	ctx, apiErr := withGameParam(r)
+	if apiErr != nil {
+		writeError(w, r, apiErr)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = formatNDJSON
+	}
+	var f exportFilter
+	var err error
+	f.PlayerID = q.Get("player_id")
+	if f.Since, err = parseTimeBound(q.Get("since")); err != nil {
+		writeError(w, r, errValidation("since must be an RFC 3339 time or a YYYY-MM-DD date"))
+		return
+	}
+	if f.Until, err = parseTimeBound(q.Get("until")); err != nil {
+		writeError(w, r, errValidation("until must be an RFC 3339 time or a YYYY-MM-DD date"))
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if format == formatCSV {
+		contentType = "text/csv; charset=utf-8"
+	} else if format != formatNDJSON {
+		writeError(w, r, errValidation(`format must be "ndjson" or "csv"`))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="checkpoints.`+format+`"`)
+
+	// The status is already sent once rows stream, so a failure part-way can
+	// only be logged; the truncated file is the client's signal.
+	n, err := exportCheckpointsTo(ctx, db, w, format, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "checkpoint export failed", "exported", n, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "checkpoints exported", "count", n, "format", format, "game", gameIDFromContext(ctx))
+}
+
+// importCheckpoints handles POST /api/admin/checkpoints/import into ?game=
+// (default bss). The body is the file; its format comes from ?format= or the
+// Content-Type.
+func importCheckpoints(w http.ResponseWriter, r *http.Request) {
+	ctx, gameErr := withGameParam(r)
+	if gameErr != nil {
+		writeError(w, r, gameErr)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = formatNDJSON
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = formatCSV
+		}
+	}
+	opts := importOptions{OnConflict: q.Get("on_conflict")}
+	var err error
+	for name, dst := range map[string]*bool{"preserve_ids": &opts.PreserveIDs, "dry_run": &opts.DryRun} {
+		if s := q.Get(name); s != "" {
+			if *dst, err = strconv.ParseBool(s); err != nil {
+				writeError(w, r, errValidation(name+" must be true or false"))
+				return
+			}
+		}
+	}
+
+	report, err := importCheckpointsFrom(ctx, db, r.Body, format, opts)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr)
+		return
+	} else if err != nil {
+		writeError(w, r, errInternal("importing checkpoints", err))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "checkpoints imported", "dry_run", report.DryRun, "created", report.Created,
+		"overwritten", report.Overwritten, "renumbered", report.Renumbered, "skipped", report.Skipped, "failed", report.Failed)
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// describeImport is the one-line summary the CLI prints.
+func describeImport(report model.ImportReport) string {
+	s := fmt.Sprintf("created %d, overwritten %d, renumbered %d, skipped %d, failed %d",
+		report.Created, report.Overwritten, report.Renumbered, report.Skipped, report.Failed)
+	if report.DryRun {
+		s = "dry run: " + s
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"studentbackendgosql/model"
+)
+
+// A record failing on a server error is reported without the cause, which
+// may describe the database.
+func TestImportHidesInternalErrors(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT import_record`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM players WHERE id = $1 AND tenant_id <> $2)`)).
+		WillReturnError(errors.New(`pq: relation "players" does not exist`))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT import_record`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	ctx := playerContext("tenant-a", "user-a", "tenant-a:user-a")
+	in := strings.NewReader(`{"user_name":"a","checkpoint_data":"{}","player_id":"p1"}` + "\n")
+	report, err := importCheckpointsFrom(ctx, conn, in, formatNDJSON, importOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 || len(report.Errors) != 1 {
+		t.Fatalf("report = %+v, want one failed record", report)
+	}
+	if got := report.Errors[0].Detail; got != "An internal error occurred" {
+		t.Errorf("detail = %q", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// An overwriting import records each checkpoint it replaces, with its hashes
+// before and after, in the import's transaction.
+func TestImportOverwriteIsAudited(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT import_record`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT tenant_id, game_id FROM gameplay_checkpoints WHERE id = $1`)).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "game_id"}).AddRow("tenant-a", model.DefaultGameID))
+	mock.ExpectQuery(regexp.QuoteMeta(`FOR UPDATE`)).
+		WithArgs(5, "tenant-a", model.DefaultGameID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "checkpoint_data", "player_id"}).AddRow("a", "old", ""))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE gameplay_checkpoints SET`)).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "last_edited_at"}).AddRow(time.Now(), time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO checkpoint_outbox`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO admin_audit_log`)).
+		WithArgs("user-a", "", auditCheckpointUpdate, 5, "",
+			checkpointHash(&Checkpoint{Username: "a", CheckpointData: "old"}),
+			checkpointHash(&Checkpoint{Username: "a", CheckpointData: "new"}),
+			"", "tenant-a").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`RELEASE SAVEPOINT import_record`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	ctx := playerContext("tenant-a", "user-a", "tenant-a:user-a")
+	in := strings.NewReader(`{"id":5,"user_name":"a","checkpoint_data":"new"}` + "\n")
+	opts := importOptions{PreserveIDs: true, OnConflict: model.ConflictOverwrite}
+	report, err := importCheckpointsFrom(ctx, conn, in, formatNDJSON, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Overwritten != 1 {
+		t.Fatalf("report = %+v, want one overwritten record", report)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"studentbackendgosql/model"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// moved to the dead-letter list.
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff is the delay before the first retry; it doubles on
+	// every further attempt up to webhookMaxBackoff.
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// webhookPollInterval is how often due deliveries are looked for.
+	webhookPollInterval = 2 * time.Second
+	// webhookBatchSize bounds the deliveries claimed per poll.
+	webhookBatchSize = 50
+	// webhookTimeout bounds a single delivery request.
+	webhookTimeout = 10 * time.Second
+	// webhookLease is how long a claimed delivery is left to its instance
+	// before another may claim it; it covers a whole batch timing out.
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+)
+
+// Headers sent with every delivery. The signature is
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by the secret>".
+const (
+	webhookSignatureHeader = "X-BSS-Signature"
+	webhookEventHeader     = "X-BSS-Event"
+	webhookDeliveryHeader  = "X-BSS-Delivery"
+)
+
+// Delivery statuses stored in webhook_deliveries.status.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+var webhookEventTypes = []string{model.EventCheckpointCreated, model.EventCheckpointUpdated, model.EventCheckpointDeleted}
+
+// WebhookSubscription is an admin-configured receiver of checkpoint events.
+// Secret is only returned when the subscription is created.
+type WebhookSubscription struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one attempt sequence to send an event to a subscription.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// webhookDispatcher records a delivery row per matching subscription for each
+// event, then sends due deliveries with retries and exponential backoff.
+type webhookDispatcher struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+func newWebhookDispatcher(db *sql.DB, client *http.Client) *webhookDispatcher {
+	return &webhookDispatcher{db: db, client: client}
+}
+
+// newWebhookClient returns the HTTP client deliveries are sent with. It
+// connects directly, never through a proxy, and refuses to connect to any
+// address webhookAddressAllowed rejects, whatever the URL's host resolves to at
+// send time and across redirects.
+func newWebhookClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// webhookDialControl rejects a connection about to be made to a disallowed
+// address.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAddressAllowed(addrPort.Addr()) {
+		return fmt.Errorf("webhook receiver address %s is not allowed", addrPort.Addr())
+	}
+	return nil
+}
+
+// webhookAddressAllowed reports whether webhooks may be sent to ip: loopback,
+// link-local, private and other non-public addresses are refused so that a
+// subscription cannot reach the server's own network.
+func webhookAddressAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// Publish implements eventSink by creating a pending delivery for every active
+// subscription to ev's type in ev's tenant. Recording the same event twice is a no-op, so the
+// outbox may redeliver safely.
+func (d *webhookDispatcher) Publish(ctx context.Context, ev model.CheckpointEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions WHERE active AND $2 = ANY(event_types) AND tenant_id = $4
+		ON CONFLICT (subscription_id, event_id) DO NOTHING`
+	_, err = d.db.ExecContext(ctx, query, ev.ID, ev.Type, payload, ev.TenantID)
+	return err
+}
+
+// run delivers due rows until ctx is cancelled.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.deliverDue(ctx); err != nil {
+				slog.Error("delivering webhooks", "error", err)
+			}
+		}
+	}
+}
+
+// deliverDue claims a batch of due deliveries and attempts each one. Claiming
+// pushes next_attempt_at out by webhookLease and commits straight away, so no
+// transaction or row lock is held while receivers are called and other server
+// instances skip the claimed rows. A delivery whose outcome is never recorded,
+// because the instance died mid-batch, is claimed again once the lease runs out.
+func (d *webhookDispatcher) deliverDue(ctx context.Context) error {
+	query := `UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 second'
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.event_type, d.payload, d.attempts, s.url, s.secret`
+	rows, err := d.db.QueryContext(ctx, query, webhookBatchSize, int(webhookLease/time.Second))
+	if err != nil {
+		return err
+	}
+	type due struct {
+		id        int64
+		eventType string
+		payload   []byte
+		attempts  int
+		url       string
+		secret    string
+	}
+	var batch []due
+	for rows.Next() {
+		var dd due
+		if err := rows.Scan(&dd.id, &dd.eventType, &dd.payload, &dd.attempts, &dd.url, &dd.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, dd)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, dd := range batch {
+		code, sendErr := d.send(ctx, dd.url, dd.secret, dd.id, dd.eventType, dd.payload)
+		attempts := dd.attempts + 1
+		var statusCode *int
+		if code != 0 {
+			statusCode = &code
+		}
+
+		if sendErr == nil {
+			_, err = d.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = 'delivered', attempts = $2, last_status_code = $3, last_error = NULL, delivered_at = now() WHERE id = $1`,
+				dd.id, attempts, statusCode)
+		} else if attempts >= webhookMaxAttempts {
+			slog.Warn("webhook delivery moved to dead-letter list", "delivery_id", dd.id, "url", dd.url, "error", sendErr)
+			_, err = d.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = 'dead', attempts = $2, last_status_code = $3, last_error = $4 WHERE id = $1`,
+				dd.id, attempts, statusCode, sendErr.Error())
+		} else {
+			_, err = d.db.ExecContext(ctx, `UPDATE webhook_deliveries SET attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5 WHERE id = $1`,
+				dd.id, attempts, statusCode, sendErr.Error(), time.Now().Add(webhookBackoff(attempts)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts one signed delivery and returns the response status code, if any.
+func (d *webhookDispatcher) send(ctx context.Context, target, secret string, deliveryID int64, eventType string, payload []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", serviceName+"-webhooks")
+	req.Header.Set(webhookEventHeader, eventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(webhookSignatureHeader, signWebhook(secret, time.Now(), payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the signature header value for payload sent at t.
+// Receivers recompute the HMAC over "<t>.<body>" and compare in constant time.
+func signWebhook(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff << (attempts - 1)
+	if d <= 0 || d > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return d
+}
+
+// createWebhook handles POST requests registering a webhook subscription. A
+// secret is generated when none is supplied and returned only in this response.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var sub WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		writeError(w, r, errBadJSON(err))
+		return
+	}
+	if err := validateWebhook(r.Context(), &sub); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if sub.Secret == "" {
+		var b [32]byte
+		rand.Read(b[:])
+		sub.Secret = hex.EncodeToString(b[:])
+	}
+
+	query := `INSERT INTO webhook_subscriptions (url, event_types, secret, tenant_id) VALUES ($1, $2, $3, $4) RETURNING id, active, created_at`
+	err := db.QueryRowContext(r.Context(), query, sub.URL, pq.Array(sub.EventTypes), sub.Secret, tenantFromContext(r.Context())).Scan(&sub.ID, &sub.Active, &sub.CreatedAt)
+	if err != nil {
+		writeError(w, r, errInternal("creating webhook", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, sub)
+}
+
+// validateWebhook checks sub and fills in its default event types. The URL's
+// host must resolve, and only to addresses webhookAddressAllowed accepts; the
+// check is repeated when connecting, as the host may later resolve elsewhere.
+func validateWebhook(ctx context.Context, sub *WebhookSubscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errValidation("url must be an absolute http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errValidation(fmt.Sprintf("url host %q does not resolve", u.Hostname()))
+	}
+	for _, addr := range addrs {
+		if !webhookAddressAllowed(addr) {
+			return errValidation("url must not point at a loopback, link-local or private address")
+		}
+	}
+	if len(sub.EventTypes) == 0 {
+		sub.EventTypes = webhookEventTypes
+	}
+	for _, t := range sub.EventTypes {
+		if !slices.Contains(webhookEventTypes, t) {
+			return errValidation(fmt.Sprintf("unknown event type %q", t))
+		}
+	}
+	return nil
+}
+
+// listWebhooks handles GET requests listing every webhook subscription in the
+// admin's tenant.
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT id, url, event_types, active, created_at FROM webhook_subscriptions WHERE tenant_id = $1 ORDER BY id`
+	rows, err := db.QueryContext(r.Context(), query, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("retrieving webhooks", err))
+		return
+	}
+	defer rows.Close()
+
+	subs := []WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Active, &sub.CreatedAt); err != nil {
+			writeError(w, r, errInternal("scanning webhook row", err))
+			return
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over webhook rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, subs)
+}
+
+// deleteWebhook handles DELETE requests removing a subscription and its deliveries.
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, errValidation("Invalid webhook ID"))
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), `DELETE FROM webhook_subscriptions WHERE id = $1 AND tenant_id = $2`, id, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("deleting webhook", err))
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		writeError(w, r, errInternal("checking rows affected", err))
+		return
+	} else if n == 0 {
+		writeError(w, r, &apiError{Status: http.StatusNotFound, Code: codeWebhookNotFound, Detail: "Webhook not found"})
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// listWebhookDeliveries handles GET requests for deliveries, newest first,
+// filtered by ?status= (dead lists the dead-letter queue) and ?subscription_id=.
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status != "" && status != deliveryPending && status != deliveryDelivered && status != deliveryDead {
+		writeError(w, r, errValidation("status must be pending, delivered or dead"))
+		return
+	}
+	var subID *int64
+	if s := q.Get("subscription_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			writeError(w, r, errValidation("Invalid subscription_id"))
+			return
+		}
+		subID = &id
+	}
+
+	query := `SELECT id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+			last_status_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE ($1 = '' OR status = $1) AND ($2::BIGINT IS NULL OR subscription_id = $2)
+			AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE tenant_id = $3)
+		ORDER BY id DESC LIMIT 500`
+	rows, err := db.QueryContext(r.Context(), query, status, subID, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("retrieving webhook deliveries", err))
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var dl WebhookDelivery
+		err := rows.Scan(&dl.ID, &dl.SubscriptionID, &dl.EventID, &dl.EventType, &dl.Payload, &dl.Status, &dl.Attempts,
+			&dl.NextAttemptAt, &dl.LastStatusCode, &dl.LastError, &dl.CreatedAt, &dl.DeliveredAt)
+		if err != nil {
+			writeError(w, r, errInternal("scanning webhook delivery row", err))
+			return
+		}
+		deliveries = append(deliveries, dl)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, r, errInternal("iterating over webhook delivery rows", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, deliveries)
+}
+
+// redeliverWebhook handles POST requests that put a delivery, typically a dead
+// one, back in the queue with a fresh set of attempts.
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, errValidation("Invalid delivery ID"))
+		return
+	}
+
+	query := `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now(), delivered_at = NULL
+		WHERE id = $1 AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE tenant_id = $2)`
+	result, err := db.ExecContext(r.Context(), query, id, tenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, errInternal("requeueing webhook delivery", err))
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		writeError(w, r, errInternal("checking rows affected", err))
+		return
+	} else if n == 0 {
+		writeError(w, r, &apiError{Status: http.StatusNotFound, Code: codeWebhookNotFound, Detail: "Webhook delivery not found"})
+		return
+	}
+
+	writeJSON(w, r, http.StatusAccepted, map[string]string{"message": "Delivery queued"})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testWebhookSecret = "s3cret"
+
+// expectClaim expects deliverDue to claim one delivery, already attempted
+// attempts times, for the receiver at url.
+func expectClaim(mock sqlmock.Sqlmock, url string, attempts int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE webhook_deliveries d SET next_attempt_at`)).
+		WithArgs(webhookBatchSize, int(webhookLease/time.Second)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "attempts", "url", "secret"}).
+			AddRow(7, "checkpoint.created", []byte(`{"id":1}`), attempts, url, testWebhookSecret))
+}
+
+// newTestDispatcher returns a dispatcher on a sqlmock, sending with client.
+func newTestDispatcher(t *testing.T, client *http.Client) (*webhookDispatcher, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return newWebhookDispatcher(conn, client), mock
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(webhookEventHeader); got != "checkpoint.created" {
+			t.Errorf("%s = %q", webhookEventHeader, got)
+		}
+		if got := r.Header.Get(webhookDeliveryHeader); got != "7" {
+			t.Errorf("%s = %q", webhookDeliveryHeader, got)
+		}
+		if err := verifySignature(r.Header.Get(webhookSignatureHeader), body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d, mock := newTestDispatcher(t, srv.Client())
+	expectClaim(mock, srv.URL, 0)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET status = 'delivered'`)).
+		WithArgs(7, 1, http.StatusNoContent).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := d.deliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifySignature checks header the way a receiver would.
+func verifySignature(header string, body []byte) error {
+	ts, sig, ok := strings.Cut(header, ",v1=")
+	ts, found := strings.CutPrefix(ts, "t=")
+	if !ok || !found {
+		return errors.New("malformed signature header " + header)
+	}
+	if _, err := strconv.ParseInt(ts, 10, 64); err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if !hmac.Equal([]byte(sig), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return errors.New("signature does not match the body")
+	}
+	return nil
+}
+
+// retryAt matches a next_attempt_at of about now plus after.
+type retryAt struct{ after time.Duration }
+
+func (a retryAt) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	delay := time.Until(t)
+	return ok && delay > a.after-time.Minute && delay <= a.after
+}
+
+func TestWebhookServerErrorIsRetriedWithBackoff(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d, mock := newTestDispatcher(t, srv.Client())
+	expectClaim(mock, srv.URL, 2)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5`)).
+		WithArgs(7, 3, http.StatusServiceUnavailable, "receiver responded 503 Service Unavailable", retryAt{4 * webhookBaseBackoff}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := d.deliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("receiver called %d times, want 1", calls)
+	}
+}
+
+func TestWebhookServerErrorOnLastAttemptIsDead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	d, mock := newTestDispatcher(t, srv.Client())
+	expectClaim(mock, srv.URL, webhookMaxAttempts-1)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET status = 'dead'`)).
+		WithArgs(7, webhookMaxAttempts, http.StatusBadGateway, "receiver responded 502 Bad Gateway").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := d.deliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  webhookBaseBackoff,
+		2:  2 * webhookBaseBackoff,
+		3:  4 * webhookBaseBackoff,
+		10: webhookMaxBackoff,
+		70: webhookMaxBackoff,
+	} {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookRejectsInternalAddresses(t *testing.T) {
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://10.0.0.5/hook",
+		"https://192.168.1.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		err := validateWebhook(context.Background(), &WebhookSubscription{URL: u})
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.Code != codeValidationFailed {
+			t.Errorf("validateWebhook(%s) = %v, want a validation error", u, err)
+		}
+	}
+	if !webhookAddressAllowed(netip.MustParseAddr("203.0.113.10")) {
+		t.Error("public address refused")
+	}
+}
+
+func TestWebhookClientRefusesLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("receiver on loopback was reached")
+	}))
+	defer srv.Close()
+
+	resp, err := newWebhookClient().Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the connection to be refused")
+	}
+	if !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
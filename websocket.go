@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"studentbackendgosql/model"
+)
+
+const (
+	// wsWriteWait bounds how long a single frame write may take.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a connection may stay silent before it is dropped.
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be shorter than wsPongWait.
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsSendBuffer is how many events may queue for a slow client before it is disconnected.
+	wsSendBuffer = 64
+	// wsMaxMessageSize bounds the control messages clients may send.
+	wsMaxMessageSize = 1024
+)
+
+// Subscription scopes a client may request.
+const (
+	wsScopeOwn = "own"
+	wsScopeAll = "all"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     wsCheckOrigin,
+}
+
+// wsCheckOrigin accepts non-browser clients (no Origin header) and browsers on
+// one of the server-wide CORS origins or, for a socket on ?game=, that game's.
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || allowsServerOrigin(origin) {
+		return true
+	}
+	cfg, ok := games.get(r.URL.Query().Get("game"))
+	return ok && slices.Contains(cfg.AllowedOrigins, origin)
+}
+
+// wsClientMessage is a control message sent by the client.
+type wsClientMessage struct {
+	Type  string `json:"type"`
+	Scope string `json:"scope"`
+}
+
+// wsServerMessage is every frame the server sends: either an event, or an
+// acknowledgement or error for a control message.
+type wsServerMessage struct {
+	Type  string                 `json:"type"`
+	Scope string                 `json:"scope,omitempty"`
+	Event *model.CheckpointEvent `json:"event,omitempty"`
+	Error *model.Problem         `json:"error,omitempty"`
+}
+
+// wsHub tracks connected WebSocket clients and forwards checkpoint events to
+// the ones subscribed to them.
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+}
+
+// wsClient is one connection, watching one game. Players only ever see their
+// own checkpoints; users with the checkpoints:read:any permission may widen
+// their subscription to every player of their tenant. The connection lasts no
+// longer than the session that opened it.
+type wsClient struct {
+	conn     *websocket.Conn
+	playerID string
+	// userID is the session's user: the admin's when impersonating.
+	userID   string
+	tenantID string
+	gameID   string
+	admin    bool
+	send     chan wsServerMessage
+	// closeReason, set before send is closed, is sent in the close frame.
+	closeReason string
+
+	mu    sync.Mutex
+	scope string
+}
+
+var hub = newWSHub()
+
+func newWSHub() *wsHub {
+	return &wsHub{clients: make(map[*wsClient]struct{})}
+}
+
+// Publish implements eventSink. Clients whose send buffer is full are dropped
+// rather than allowed to stall the dispatcher.
+func (h *wsHub) Publish(_ context.Context, ev model.CheckpointEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if !c.wants(ev) {
+			continue
+		}
+		select {
+		case c.send <- wsServerMessage{Type: "event", Event: &ev}:
+		default:
+			slog.Warn("dropping slow websocket client", "player_id", c.playerID)
+			delete(h.clients, c)
+			close(c.send)
+		}
+	}
+	return nil
+}
+
+func (h *wsHub) add(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+func (h *wsHub) remove(c *wsClient) {
+	h.disconnect(c, "")
+}
+
+// disconnect drops c, closing its connection with reason once the messages
+// already queued are sent.
+func (h *wsHub) disconnect(c *wsClient, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		c.closeReason = reason
+		close(c.send)
+	}
+}
+
+// closeUser disconnects every client of userID's sessions.
+func (h *wsHub) closeUser(userID, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if c.userID == userID {
+			delete(h.clients, c)
+			c.closeReason = reason
+			close(c.send)
+		}
+	}
+}
+
+// sendTo queues a control reply for c unless the hub has already dropped it.
+func (h *wsHub) sendTo(c *wsClient, m wsServerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- m:
+	default:
+	}
+}
+
+func (c *wsClient) wants(ev model.CheckpointEvent) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ev.TenantID != c.tenantID || ev.GameID != c.gameID {
+		return false
+	}
+	return c.scope == wsScopeAll || ev.PlayerID == c.playerID
+}
+
+// checkpointEventsSocket upgrades an authenticated request to a WebSocket that
+// streams the checkpoint events of the game named by ?game=, or of the default
+// game. Clients start subscribed to their own checkpoints
+// (or to ?scope=all with checkpoints:read:any) and may change scope by sending
+// {"type":"subscribe","scope":"own"|"all"}. The socket is closed when the
+// session token expires or the user's sessions are revoked.
+func checkpointEventsSocket(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := r.Context().Value(contextKeyPlayerID).(string)
+	if !ok || playerID == "" {
+		writeError(w, r, errForbidden(codeForbidden, "Player ID not found in session"))
+		return
+	}
+	ctx, apiErr := withGameParam(r)
+	if apiErr != nil {
+		writeError(w, r, apiErr)
+		return
+	}
+	admin := hasPermission(r.Context(), permCheckpointsReadAny)
+
+	scope := wsScopeOwn
+	if r.URL.Query().Get("scope") == wsScopeAll {
+		if !admin {
+			writeError(w, r, errForbidden(codeForbidden, "Subscribing to all checkpoints requires the "+permCheckpointsReadAny+" permission"))
+			return
+		}
+		scope = wsScopeAll
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		slog.WarnContext(r.Context(), "websocket upgrade failed", "error", err)
+		return
+	}
+
+	userID := impersonatorFromContext(r.Context())
+	if userID == "" {
+		userID, _ = r.Context().Value(contextKeyUserID).(string)
+	}
+	c := &wsClient{
+		conn:     conn,
+		playerID: playerID,
+		userID:   userID,
+		tenantID: tenantFromContext(r.Context()),
+		gameID:   gameIDFromContext(ctx),
+		admin:    admin,
+		send:     make(chan wsServerMessage, wsSendBuffer),
+		scope:    scope,
+	}
+	c.send <- wsServerMessage{Type: "subscribed", Scope: scope}
+	hub.add(c)
+	slog.InfoContext(r.Context(), "websocket client connected", "scope", scope)
+	if expires, ok := r.Context().Value(contextKeySessionExpires).(time.Time); ok {
+		expiry := time.AfterFunc(time.Until(expires), func() { hub.disconnect(c, "session expired") })
+		defer expiry.Stop()
+	}
+
+	go c.writePump()
+	c.readPump()
+	hub.remove(c)
+	slog.InfoContext(r.Context(), "websocket client disconnected")
+}
+
+// readPump handles control messages until the connection closes.
+func (c *wsClient) readPump() {
+	defer c.conn.Close()
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg wsClientMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Debug("websocket read failed", "player_id", c.playerID, "error", err)
+			}
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+func (c *wsClient) handle(msg wsClientMessage) {
+	reply := func(m wsServerMessage) { hub.sendTo(c, m) }
+
+	if msg.Type != "subscribe" || (msg.Scope != wsScopeOwn && msg.Scope != wsScopeAll) {
+		reply(wsServerMessage{Type: "error", Error: &model.Problem{
+			Status: http.StatusBadRequest,
+			Code:   codeValidationFailed,
+			Detail: `Expected {"type":"subscribe","scope":"own"|"all"}`,
+		}})
+		return
+	}
+	if msg.Scope == wsScopeAll && !c.admin {
+		reply(wsServerMessage{Type: "error", Error: &model.Problem{
+			Status: http.StatusForbidden,
+			Code:   codeForbidden,
+			Detail: "Subscribing to all checkpoints requires the " + permCheckpointsReadAny + " permission",
+		}})
+		return
+	}
+
+	c.mu.Lock()
+	c.scope = msg.Scope
+	c.mu.Unlock()
+	reply(wsServerMessage{Type: "subscribed", Scope: msg.Scope})
+}
+
+// writePump sends queued messages and keepalive pings until send is closed.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeMessage is the close frame for a client the hub has dropped: a policy
+// violation with the reason if there is one, else empty.
+func (c *wsClient) closeMessage() []byte {
+	if c.closeReason == "" {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.closeReason)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/descope/go-sdk/descope"
+	"github.com/gorilla/websocket"
+
+	"studentbackendgosql/model"
+)
+
+func TestWSClientOnlyWantsItsGame(t *testing.T) {
+	c := &wsClient{playerID: "p1", tenantID: "t1", gameID: model.DefaultGameID, scope: wsScopeAll}
+	for _, tc := range []struct {
+		ev   model.CheckpointEvent
+		want bool
+	}{
+		{model.CheckpointEvent{PlayerID: "p1", TenantID: "t1", GameID: model.DefaultGameID}, true},
+		{model.CheckpointEvent{PlayerID: "p2", TenantID: "t1", GameID: model.DefaultGameID}, true},
+		{model.CheckpointEvent{PlayerID: "p1", TenantID: "t1", GameID: "other"}, false},
+		{model.CheckpointEvent{PlayerID: "p1", TenantID: "t2", GameID: model.DefaultGameID}, false},
+	} {
+		if got := c.wants(tc.ev); got != tc.want {
+			t.Errorf("wants(%+v) = %v, want %v", tc.ev, got, tc.want)
+		}
+	}
+}
+
+// dialEvents opens the event socket with token and reads the initial
+// subscription acknowledgement.
+func dialEvents(t *testing.T, token string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(newRouter())
+	t.Cleanup(srv.Close)
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var msg wsServerMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "subscribed" {
+		t.Fatalf("first message %+v, %v", msg, err)
+	}
+	return conn
+}
+
+// expectClosed reads from conn until it is closed, which must happen with a
+// policy violation giving reason.
+func expectClosed(t *testing.T, conn *websocket.Conn, reason string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != reason {
+		t.Errorf("read error %v, want close %d %q", err, websocket.ClosePolicyViolation, reason)
+	}
+}
+
+func TestWSClosedWhenSessionsRevoked(t *testing.T) {
+	fakeSession(t, "tok-a", "user-a", "tenant-a")
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	conn := dialEvents(t, "tok-a")
+
+	sessions.dropUser("user-a")
+	expectClosed(t, conn, "session revoked")
+}
+
+func TestWSClosedWhenSessionExpires(t *testing.T) {
+	sessions.put("tok-a", &descope.Token{ID: "user-a", Expiration: time.Now().Add(2 * time.Second).Unix(), Claims: map[string]any{
+		descope.ClaimAuthorizedTenants: map[string]any{"tenant-a": map[string]any{}},
+	}})
+	t.Cleanup(func() { sessions.dropUser("user-a") })
+	mock := mockDB(t)
+	expectDefaultPlayer(mock, "tenant-a", "user-a")
+	conn := dialEvents(t, "tok-a")
+
+	expectClosed(t, conn, "session expired")
+}